@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/finkf/pcwgo/api"
+	"github.com/spf13/cobra"
+)
+
+var applyArgs = struct {
+	pattern       string
+	minConfidence float64
+	minWeight     float64
+	maxDistance   int
+	onlyUntaken   bool
+	dryRun        bool
+}{}
+
+func init() {
+	applyCommand.PersistentFlags().BoolVarP(&applyArgs.dryRun, "dry-run", "n",
+		false, "only print the corrections that would be applied")
+	applyRRDMCommand.Flags().Float64VarP(&applyArgs.minConfidence,
+		"min-confidence", "c", 0.9, "set the minimal confidence")
+	applyRRDMCommand.Flags().BoolVarP(&applyArgs.onlyUntaken, "only-untaken",
+		"u", false, "only apply decisions that were not taken")
+	applySuggestionsCommand.Flags().Float64VarP(&applyArgs.minWeight,
+		"min-weight", "w", 0, "set the minimal weight of suggestions")
+	applySuggestionsCommand.Flags().IntVarP(&applyArgs.maxDistance,
+		"max-distance", "d", -1, "set the maximal distance of suggestions (-1: no limit)")
+	applySuggestionsCommand.Flags().StringVarP(&applyArgs.pattern,
+		"pattern", "p", "", "only apply suggestions with the given ocr pattern")
+}
+
+var applyCommand = cobra.Command{
+	Use:   "apply",
+	Short: "Apply automatic correction decisions",
+}
+
+// applySummary summarizes the results of apply commands.  With
+// --dry-run, nothing is applied and the corrections are counted as
+// WouldApply.
+type applySummary struct {
+	BookID     int `json:"bookId"`
+	Applied    int `json:"applied"`
+	WouldApply int `json:"wouldApply"`
+	Skipped    int `json:"skipped"`
+	Conflicts  int `json:"conflicts"`
+}
+
+// suggestionMatch is printed by apply suggestions --dry-run for each
+// word.  Count is the number of tokens that would be corrected.
+type suggestionMatch struct {
+	Word        string   `json:"word"`
+	Suggestion  string   `json:"suggestion"`
+	OCRPatterns []string `json:"ocrPatterns"`
+	Count       int      `json:"count"`
+}
+
+// conflictError is returned for corrections that conflict with the
+// current state of a token.
+type conflictError string
+
+func (err conflictError) Error() string {
+	return string(err)
+}
+
+// count counts the result of a correction.  Errors other than
+// skipError and conflictError are returned.  Corrections that could not
+// be recorded in the journal are counted as applied.
+func (sum *applySummary) count(id string, err error) error {
+	switch err.(type) {
+	case nil:
+		sum.Applied++
+	case journalError:
+		sum.Applied++
+		return err
+	case skipError:
+		sum.Skipped++
+	case conflictError:
+		fmt.Fprintf(os.Stderr, "conflict %s: %v\n", id, err)
+		sum.Conflicts++
+	default:
+		return err
+	}
+	return nil
+}
+
+var applyRRDMCommand = cobra.Command{
+	Use:   "rrdm ID",
+	Short: "Apply the automatic post-correction of book ID",
+	Args:  cobra.ExactArgs(1),
+	RunE:  doApplyRRDM,
+	Long: `
+Apply the decisions of the automatic post-correction of book ID as
+automatic corrections.  Only decisions with a confidence of at least
+--min-confidence are applied.  Tokens that are manually corrected or
+have been corrected differently are reported as conflicts and are not
+changed.`,
+}
+
+func doApplyRRDM(_ *cobra.Command, args []string) error {
+	ids, err := parseID(args[0], bookLevels[:1])
+	if err != nil {
+		return fmt.Errorf("apply rrdm: %v", err)
+	}
+	bid := ids[0]
+	c := authenticate()
+	var pcs api.PostCorrection
+	if err := get(c, c.URL("postcorrect/books/%d", bid), &pcs); err != nil {
+		return fmt.Errorf("apply rrdm for book %d: %v", bid, err)
+	}
+	var keys []string
+	for key := range pcs.Corrections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sum := applySummary{BookID: bid}
+	dry := api.PostCorrection{BookID: bid, ProjectID: pcs.ProjectID,
+		Corrections: make(map[string]api.PostCorrectionToken)}
+	for _, key := range keys {
+		pc := pcs.Corrections[key]
+		if pc.Confidence < applyArgs.minConfidence ||
+			(applyArgs.onlyUntaken && pc.Taken) {
+			sum.Skipped++
+			continue
+		}
+		id := fmt.Sprintf("%d:%d:%d:%d", bid, pc.PageID, pc.LineID, pc.TokenID)
+		if applyArgs.dryRun {
+			dry.Corrections[key] = pc
+			sum.WouldApply++
+			continue
+		}
+		_, err := applyCorrectionWith(c, id, "automatic", pc.Cor, correctionOptions{
+			check: func(now correctionState) error {
+				return checkAutomaticCorrection(now, pc.OCR, pc.Cor)
+			},
+		})
+		if err := sum.count(id, err); err != nil {
+			return fmt.Errorf("apply rrdm for book %d: correct %s: %v", bid, id, err)
+		}
+	}
+	if len(dry.Corrections) > 0 {
+		format(&dry)
+	}
+	format(&sum)
+	return nil
+}
+
+// checkAutomaticCorrection checks if a token with the given ocr can
+// be automatically corrected to cor.
+func checkAutomaticCorrection(now correctionState, ocr, cor string) error {
+	if now.Cor == cor {
+		return skipError("already corrected")
+	}
+	if now.IsManuallyCorrected {
+		return conflictError("manually corrected: " + s(now.Cor))
+	}
+	if now.Cor != ocr {
+		return conflictError("corrected differently: " + s(now.Cor))
+	}
+	return nil
+}
+
+// checkSuggestion checks if a token can be automatically corrected
+// with the suggestion for the suspicious word.  The current text of
+// the token must still be the word (ignoring case).
+func checkSuggestion(now correctionState, word, sugg string) error {
+	ocr := word
+	if strings.EqualFold(now.Cor, word) {
+		ocr = now.Cor
+	}
+	return checkAutomaticCorrection(now, ocr, matchCase(now.Cor, sugg))
+}
+
+var applySuggestionsCommand = cobra.Command{
+	Use:   "suggestions ID",
+	Short: "Apply the profiler suggestions of book ID",
+	Args:  cobra.ExactArgs(1),
+	RunE:  doApplySuggestions,
+	Long: `
+Apply the profiler suggestions for the suspicious words of book ID
+as automatic corrections.  For each suspicious word, the suggestion
+with the highest weight that passes --min-weight, --max-distance and
+--pattern is chosen and all occurrences of the word are corrected.
+Patterns are given as LEFT:RIGHT.  Tokens that are manually corrected
+or have been corrected differently are reported as conflicts and are
+not changed.  The case of each correction is adjusted to the current
+text of the token.  With --dry-run, the words, the chosen
+suggestions, their ocr patterns and the number of occurrences are
+printed.`,
+}
+
+func doApplySuggestions(_ *cobra.Command, args []string) error {
+	ids, err := parseID(args[0], bookLevels[:1])
+	if err != nil {
+		return fmt.Errorf("apply suggestions: %v", err)
+	}
+	bid := ids[0]
+	c := authenticate()
+	var counts api.SuggestionCounts
+	if err := get(c, c.URL("profile/suspicious/books/%d", bid), &counts); err != nil {
+		return fmt.Errorf("apply suggestions for book %d: %v", bid, err)
+	}
+	var words []string
+	for word := range counts.Counts {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	sum := applySummary{BookID: bid}
+	for _, word := range words {
+		sugg, ok, err := bestSuggestion(c, bid, word)
+		if err != nil {
+			return fmt.Errorf("apply suggestions for book %d: %v", bid, err)
+		}
+		if !ok {
+			sum.Skipped++
+			continue
+		}
+		rs, err := searchReplacements(c, bid, string(api.SearchToken), word, true,
+			func(cor string) string { return matchCase(cor, sugg.Suggestion) })
+		if err != nil {
+			return fmt.Errorf("apply suggestions for book %d: %v", bid, err)
+		}
+		if applyArgs.dryRun {
+			format(&suggestionMatch{Word: word, Suggestion: sugg.Suggestion,
+				OCRPatterns: sugg.OCRPatterns, Count: len(rs)})
+			sum.WouldApply += len(rs)
+			continue
+		}
+		for _, r := range rs {
+			_, err := applyCorrectionWith(c, r.ID, "automatic", r.New, correctionOptions{
+				replace: func(cor string) string {
+					return matchCase(cor, sugg.Suggestion)
+				},
+				check: func(now correctionState) error {
+					return checkSuggestion(now, word, sugg.Suggestion)
+				},
+			})
+			if err := sum.count(r.ID, err); err != nil {
+				return fmt.Errorf("apply suggestions for book %d: correct %s: %v",
+					bid, r.ID, err)
+			}
+		}
+	}
+	format(&sum)
+	return nil
+}
+
+// bestSuggestion returns the suggestion for word with the highest
+// weight that passes all filters.
+func bestSuggestion(c *api.Client, bid int, word string) (api.Suggestion, bool, error) {
+	var suggs api.Suggestions
+	uri := c.URL("profile/books/%d?q=%s", bid, url.QueryEscape(word))
+	if err := get(c, uri, &suggs); err != nil {
+		return api.Suggestion{}, false, err
+	}
+	var best api.Suggestion
+	var found bool
+	for _, ss := range suggs.Suggestions {
+		for _, s := range ss {
+			if s.Weight < applyArgs.minWeight ||
+				(applyArgs.maxDistance >= 0 && s.Distance > applyArgs.maxDistance) ||
+				!hasOCRPattern(s, applyArgs.pattern) {
+				continue
+			}
+			if !found || s.Weight > best.Weight {
+				best, found = s, true
+			}
+		}
+	}
+	return best, found, nil
+}
+
+func hasOCRPattern(s api.Suggestion, pattern string) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, p := range s.OCRPatterns {
+		if p == pattern || strings.HasPrefix(p, pattern+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCase adjusts the case of the lower case suggestion to the
+// case of the given token.
+func matchCase(token, sugg string) string {
+	rs := []rune(token)
+	switch {
+	case len(rs) == 0 || !unicode.IsUpper(rs[0]):
+		return sugg
+	case token == strings.ToUpper(token) && len(rs) > 1:
+		return strings.ToUpper(sugg)
+	default:
+		ss := []rune(sugg)
+		if len(ss) == 0 {
+			return sugg
+		}
+		return string(unicode.ToUpper(ss[0])) + string(ss[1:])
+	}
+}
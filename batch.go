@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/finkf/pcwgo/api"
+)
+
+// batchCorrection defines one correction of a batch input file.
+type batchCorrection struct {
+	ID          string  `json:"id"`
+	Correction  string  `json:"correction"`
+	Type        string  `json:"type,omitempty"`
+	Expected    *string `json:"expected,omitempty"`
+	ExpectedOCR *string `json:"expectedOcr,omitempty"`
+}
+
+// batchResult defines the report entry for one correction of a batch
+// input file.
+type batchResult struct {
+	Line       int    `json:"line"`
+	ID         string `json:"id,omitempty"`
+	Correction string `json:"correction,omitempty"`
+	Status     string `json:"status"` // ok, failed or skipped
+	Error      string `json:"error,omitempty"`
+}
+
+// Status values of batch results.
+const (
+	batchOK      = "ok"
+	batchFailed  = "failed"
+	batchSkipped = "skipped"
+)
+
+func correctBatch(c *api.Client) error {
+	parse, err := batchParser(correctArgs.inputFormat)
+	if err != nil {
+		return fmt.Errorf("cannot correct: %v", err)
+	}
+	in, err := openInput(correctArgs.input)
+	if err != nil {
+		return fmt.Errorf("cannot correct: %v", err)
+	}
+	defer in.Close()
+	out := io.WriteCloser(nopWriteCloser{os.Stdout})
+	if correctArgs.report != "" {
+		if out, err = os.Create(correctArgs.report); err != nil {
+			return fmt.Errorf("cannot correct: %v", err)
+		}
+	}
+	counts := make(map[string]int)
+	journalErrors := 0
+	enc := json.NewEncoder(out)
+	s := bufio.NewScanner(in)
+	for lineno := 1; s.Scan(); lineno++ {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		res := batchResult{Line: lineno, Status: batchOK}
+		if bc, err := parse(line); err != nil {
+			res.Status, res.Error = batchFailed, err.Error()
+		} else {
+			res.ID, res.Correction = bc.ID, bc.Correction
+			if err := correctBatchItem(c, bc); err != nil {
+				res.Status, res.Error = batchFailed, err.Error()
+				switch err.(type) {
+				case skipError:
+					res.Status = batchSkipped
+				case journalError:
+					res.Status = batchOK // the correction was applied
+					journalErrors++
+				}
+			}
+		}
+		counts[res.Status]++
+		if err := enc.Encode(res); err != nil {
+			out.Close()
+			return fmt.Errorf("cannot correct: write report: %v", err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		out.Close()
+		return fmt.Errorf("cannot correct: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("cannot correct: write report: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d ok, %d failed, %d skipped\n",
+		counts[batchOK], counts[batchFailed], counts[batchSkipped])
+	if counts[batchFailed] > 0 {
+		return fmt.Errorf("cannot correct: %d correction(s) failed", counts[batchFailed])
+	}
+	if journalErrors > 0 {
+		return fmt.Errorf("cannot write journal: %d correction(s) not recorded", journalErrors)
+	}
+	return skipCount(counts[batchSkipped]).err()
+}
+
+func correctBatchItem(c *api.Client, bc batchCorrection) error {
+	typ := bc.Type
+	if typ == "" {
+		typ = correctArgs.typ
+	}
+	opts := correctOptions(bc.Expected, bc.ExpectedOCR)
+	_, err := applyCorrectionWith(c, bc.ID, typ, bc.Correction, opts)
+	return err
+}
+
+func batchParser(format string) (func(string) (batchCorrection, error), error) {
+	switch format {
+	case "tsv":
+		return parseTSVCorrection, nil
+	case "jsonl":
+		return parseJSONCorrection, nil
+	default:
+		return nil, fmt.Errorf("invalid input format: %q", format)
+	}
+}
+
+// parseTSVCorrection parses a line of tab separated columns ID,
+// CORRECTION, TYPE (optional), EXPECTED (optional) and EXPECTED-OCR
+// (optional).  The escape sequences \t, \n and \\ in CORRECTION,
+// EXPECTED and EXPECTED-OCR are unescaped; any other text is kept as
+// it is.  Empty TYPE, EXPECTED and EXPECTED-OCR columns are ignored.
+func parseTSVCorrection(line string) (batchCorrection, error) {
+	var bc batchCorrection
+	cols := strings.Split(line, "\t")
+	if len(cols) < 2 || len(cols) > 5 {
+		return bc, fmt.Errorf("invalid number of columns: %d", len(cols))
+	}
+	bc.ID, bc.Correction = cols[0], tsvUnescaper.Replace(cols[1])
+	if len(cols) > 2 {
+		bc.Type = cols[2]
+	}
+	for i, expected := range []**string{&bc.Expected, &bc.ExpectedOCR} {
+		if len(cols) <= i+3 || cols[i+3] == "" {
+			continue
+		}
+		str := tsvUnescaper.Replace(cols[i+3])
+		*expected = &str
+	}
+	return bc, nil
+}
+
+// tsvUnescaper unescapes tabs, newlines and backslashes in tsv
+// columns.
+var tsvUnescaper = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n")
+
+func parseJSONCorrection(line string) (batchCorrection, error) {
+	var bc batchCorrection
+	if err := json.Unmarshal([]byte(line), &bc); err != nil {
+		return bc, err
+	}
+	if bc.ID == "" {
+		return bc, fmt.Errorf("missing id")
+	}
+	return bc, nil
+}
+
+// openInput opens the given input file.  The input - is stdin, which
+// is not closed by Close.
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// nopWriteCloser wraps a writer that must not be closed.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
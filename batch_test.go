@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func strptr(str string) *string {
+	return &str
+}
+
+func TestParseTSVCorrection(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want batchCorrection
+		err  string
+	}{
+		{"1:2:3\tabc", batchCorrection{ID: "1:2:3", Correction: "abc"}, ""},
+		{"1:2:3\ta b\tmanual", batchCorrection{ID: "1:2:3", Correction: "a b", Type: "manual"}, ""},
+		{"1:2:3:4\tx\t\tx\ty", batchCorrection{ID: "1:2:3:4", Correction: "x",
+			Expected: strptr("x"), ExpectedOCR: strptr("y")}, ""},
+		{"1:2:3\tx\tautomatic\t\ty", batchCorrection{ID: "1:2:3", Correction: "x",
+			Type: "automatic", ExpectedOCR: strptr("y")}, ""},
+		{`1:2:3	a\tb\nc\\d`, batchCorrection{ID: "1:2:3", Correction: "a\tb\nc\\d"}, ""},
+		{`1:2:3	"quoted"`, batchCorrection{ID: "1:2:3", Correction: `"quoted"`}, ""},
+		{`1:2:3	a\b\x41ä`, batchCorrection{ID: "1:2:3", Correction: `a\b\x41ä`}, ""},
+		{`1:2:3	a\\tb\`, batchCorrection{ID: "1:2:3", Correction: `a\tb\`}, ""},
+		{`1:2:3	x		"a\tb"	\\`, batchCorrection{ID: "1:2:3", Correction: "x",
+			Expected: strptr("\"a\tb\""), ExpectedOCR: strptr(`\`)}, ""},
+		{"1:2:3", batchCorrection{}, "invalid number of columns: 1"},
+		{"1\t2\t3\t4\t5\t6", batchCorrection{}, "invalid number of columns: 6"},
+	} {
+		t.Run(tc.line, func(t *testing.T) {
+			got, err := parseTSVCorrection(tc.line)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q; got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %+v; got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseJSONCorrection(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want batchCorrection
+		err  string
+	}{
+		{`{"id":"1:2:3","correction":"abc"}`, batchCorrection{ID: "1:2:3", Correction: "abc"}, ""},
+		{`{"id":"1:2:3","correction":"a\tb\\c\"","type":"manual"}`,
+			batchCorrection{ID: "1:2:3", Correction: "a\tb\\c\"", Type: "manual"}, ""},
+		{`{"id":"1:2:3:4","correction":"x","expected":"","expectedOcr":"y"}`,
+			batchCorrection{ID: "1:2:3:4", Correction: "x", Expected: strptr(""),
+				ExpectedOCR: strptr("y")}, ""},
+		{`{"id":"1:2:3","correction":""}`, batchCorrection{ID: "1:2:3"}, ""},
+		{`{"correction":"x"}`, batchCorrection{}, "missing id"},
+		{`{"id":"1:2:3",`, batchCorrection{}, "unexpected end"},
+		{"1:2:3\tx", batchCorrection{}, "invalid character"},
+	} {
+		t.Run(tc.line, func(t *testing.T) {
+			got, err := parseJSONCorrection(tc.line)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q; got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %+v; got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestBatchParser(t *testing.T) {
+	for _, format := range []string{"tsv", "jsonl"} {
+		if _, err := batchParser(format); err != nil {
+			t.Errorf("%s: got error: %v", format, err)
+		}
+	}
+	if _, err := batchParser("csv"); err == nil {
+		t.Errorf("csv: expected error")
+	}
+}
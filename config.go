@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var configArgs = struct {
+	email, credentialHelper string
+	setDefault              bool
+}{}
+
+func init() {
+	configAddCommand.Flags().BoolVarP(&configArgs.setDefault, "default", "d",
+		false, "make the new profile the default profile")
+	configAddCommand.Flags().StringVarP(&configArgs.email, "email", "e",
+		"", "set the login email of the profile")
+	configAddCommand.Flags().StringVarP(&configArgs.credentialHelper,
+		"credential-helper", "c", "",
+		"set a command that prints the login password of the profile")
+}
+
+// profile defines a named server configuration.
+type profile struct {
+	Name             string `json:"-"`
+	URL              string `json:"url"`
+	Token            string `json:"token,omitempty"`
+	Email            string `json:"email,omitempty"`
+	CredentialHelper string `json:"credentialHelper,omitempty"`
+	SkipVerify       bool   `json:"skipVerify"`
+}
+
+// config holds the named profiles of the config file.
+type config struct {
+	Default  string             `json:"default"`
+	Profiles map[string]profile `json:"profiles"`
+}
+
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pcwclient"), nil
+}
+
+func configPath() (string, error) {
+	if path := os.Getenv("POCOWEB_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config"), nil
+}
+
+// readConfig reads the config file.  A missing config file is not
+// an error and results in an empty configuration.
+func readConfig() (*config, error) {
+	cfg := config{Profiles: make(map[string]profile)}
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("read config %s: %v", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]profile)
+	}
+	for name, p := range cfg.Profiles {
+		p.Name = name
+		cfg.Profiles[name] = p
+	}
+	return &cfg, nil
+}
+
+func (cfg *config) write() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return err
+	}
+	// The config file may contain auth tokens.
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func (cfg *config) names() []string {
+	var names []string
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// activeProfile caches the profile selected with the --profile value
+// activeProfileFlag.
+var (
+	activeProfile     *profile
+	activeProfileFlag string
+)
+
+// getProfile returns the active profile.  This is either the profile
+// given with --profile or the default profile of the config file.  If
+// no profile is active, an empty profile is returned.
+func getProfile() profile {
+	if activeProfile != nil && activeProfileFlag == mainArgs.profile {
+		return *activeProfile
+	}
+	cfg, err := readConfig()
+	chk(err)
+	name := mainArgs.profile
+	if name == "" {
+		name = cfg.Default
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok && mainArgs.profile != "" {
+		chk(fmt.Errorf("no such profile: %s", mainArgs.profile))
+	}
+	activeProfile, activeProfileFlag = &p, mainArgs.profile
+	return p
+}
+
+var configCommand = cobra.Command{
+	Use:   "config",
+	Short: "Manage server profiles",
+	Long: `
+Manage named server profiles.  Profiles are stored in the file
+$XDG_CONFIG_HOME/pcwclient/config (or in the file given by the
+POCOWEB_CONFIG environment variable).  Use --profile to select a
+profile.  If no profile is given, the default profile is used.`,
+}
+
+var configAddCommand = cobra.Command{
+	Use:   "add NAME URL",
+	Short: "Add or replace the profile NAME",
+	Args:  cobra.ExactArgs(2),
+	RunE:  doConfigAdd,
+	Long: `
+Add or replace the profile NAME for the pocoweb instance at URL.
+Use the global --auth and --skip-verify flags to store an
+authentification token and to ignore invalid ssl certificates for
+the profile.
+
+If --email and --credential-helper are given, the client logs in
+again if the session has expired.  The credential helper is run
+using "sh -c" and must print the password on its first output line,
+e.g. --credential-helper "pass show pocoweb/prod".`,
+}
+
+func doConfigAdd(_ *cobra.Command, args []string) error {
+	cfg, err := readConfig()
+	if err != nil {
+		return fmt.Errorf("add profile: %v", err)
+	}
+	name := args[0]
+	cfg.Profiles[name] = profile{
+		URL:              args[1],
+		Token:            mainArgs.authToken,
+		Email:            configArgs.email,
+		CredentialHelper: configArgs.credentialHelper,
+		SkipVerify:       mainArgs.skipVerify,
+	}
+	if configArgs.setDefault || len(cfg.Profiles) == 1 {
+		cfg.Default = name
+	}
+	if err := cfg.write(); err != nil {
+		return fmt.Errorf("add profile %s: %v", name, err)
+	}
+	return nil
+}
+
+var configListCommand = cobra.Command{
+	Use:   "list",
+	Short: "List profiles",
+	Args:  cobra.NoArgs,
+	RunE:  doConfigList,
+}
+
+func doConfigList(_ *cobra.Command, args []string) error {
+	cfg, err := readConfig()
+	if err != nil {
+		return fmt.Errorf("list profiles: %v", err)
+	}
+	format(cfg)
+	return nil
+}
+
+var configRemoveCommand = cobra.Command{
+	Use:   "remove NAME...",
+	Short: "Remove profiles",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  doConfigRemove,
+}
+
+func doConfigRemove(_ *cobra.Command, args []string) error {
+	cfg, err := readConfig()
+	if err != nil {
+		return fmt.Errorf("remove profile: %v", err)
+	}
+	remove := make(map[string]bool)
+	for _, name := range args {
+		if _, ok := cfg.Profiles[name]; !ok {
+			return fmt.Errorf("remove profile: no such profile: %s", name)
+		}
+		remove[name] = true
+	}
+	// The builtin delete is shadowed by the delete helper in util.go.
+	profiles := make(map[string]profile)
+	for name, p := range cfg.Profiles {
+		if !remove[name] {
+			profiles[name] = p
+		}
+	}
+	cfg.Profiles = profiles
+	if remove[cfg.Default] {
+		cfg.Default = ""
+	}
+	if err := cfg.write(); err != nil {
+		return fmt.Errorf("remove profile: %v", err)
+	}
+	return nil
+}
+
+var configDefaultCommand = cobra.Command{
+	Use:   "default NAME",
+	Short: "Set the default profile",
+	Args:  cobra.ExactArgs(1),
+	RunE:  doConfigDefault,
+}
+
+func doConfigDefault(_ *cobra.Command, args []string) error {
+	cfg, err := readConfig()
+	if err != nil {
+		return fmt.Errorf("set default profile: %v", err)
+	}
+	if _, ok := cfg.Profiles[args[0]]; !ok {
+		return fmt.Errorf("set default profile: no such profile: %s", args[0])
+	}
+	cfg.Default = args[0]
+	if err := cfg.write(); err != nil {
+		return fmt.Errorf("set default profile: %v", err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -12,8 +13,16 @@ import (
 )
 
 var correctArgs = struct {
-	typ   string
-	stdin bool
+	typ          string
+	input        string
+	inputFormat  string
+	report       string
+	expectOCR    string
+	expectCor    string
+	expectOCRSet bool
+	expectCorSet bool
+	stdin        bool
+	skipManual   bool
 }{}
 
 func init() {
@@ -21,6 +30,18 @@ func init() {
 		"automatic", "set correction type")
 	correctCommand.Flags().BoolVarP(&correctArgs.stdin, "stdin", "i",
 		false, "read IDs and corrections from stdin")
+	correctCommand.Flags().StringVarP(&correctArgs.input, "input", "I",
+		"", "read corrections from the given file (- for stdin)")
+	correctCommand.Flags().StringVarP(&correctArgs.inputFormat, "input-format",
+		"f", "tsv", "set the format of the input file (tsv|jsonl)")
+	correctCommand.Flags().StringVarP(&correctArgs.report, "report", "r",
+		"", "write the report of --input to the given file (default: stdout)")
+	correctCommand.Flags().StringVarP(&correctArgs.expectOCR, "expect-ocr", "O",
+		"", "only correct if the current ocr matches")
+	correctCommand.Flags().StringVarP(&correctArgs.expectCor, "expect-cor", "C",
+		"", "only correct if the current correction matches")
+	correctCommand.Flags().BoolVarP(&correctArgs.skipManual, "skip-manual", "m",
+		false, "never overwrite manual corrections")
 }
 
 var correctCommand = cobra.Command{
@@ -28,19 +49,48 @@ var correctCommand = cobra.Command{
 	Short: "Correct lines or words",
 	Args:  cobra.MinimumNArgs(0),
 	RunE:  doCorrect,
+	Long: `
+Correct lines or words.  The corrections are either given as pairs
+of IDs and corrections on the command line, as space separated pairs
+on stdin (--stdin) or in a structured input file (--input).  IDs on
+the command line and on stdin can contain ranges, lists, wildcards
+and the keywords first and last (see print).
+
+Before a correction is applied, the current line or word is checked
+against --expect-ocr and --expect-cor.  If it does not match (or if
+it is manually corrected and --skip-manual is given), the correction
+is skipped.  This prevents overwriting the corrections of others.
+If any correction is skipped, the command exits with 4.
+
+Input files in tsv format contain the tab separated columns ID,
+CORRECTION and optionally TYPE, EXPECTED and EXPECTED-OCR.  In tsv
+columns, \t, \n and \\ denote a tab, a newline and a backslash.
+Input files in jsonl format contain one json object per line with the
+keys "id", "correction" and optionally "type", "expected" and
+"expectedOcr".  EXPECTED and EXPECTED-OCR overwrite --expect-cor and
+--expect-ocr.  Processing continues after failed corrections.  A
+report of all successful, failed and skipped corrections is written
+in jsonl format.  The command fails if any correction failed and
+exits with 4 if corrections were skipped but none failed.`,
 }
 
-func doCorrect(_ *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+func doCorrect(cmd *cobra.Command, args []string) error {
+	correctArgs.expectCorSet = cmd.Flags().Changed("expect-cor")
+	correctArgs.expectOCRSet = cmd.Flags().Changed("expect-ocr")
+	c := authenticate()
+	if correctArgs.input != "" {
+		return correctBatch(c)
+	}
+	var skipped skipCount
 	if !correctArgs.stdin {
 		for i := 1; i < len(args); i += 2 {
 			id := args[i-1]
 			cor := args[i]
-			if err := correct(c, id, cor, correctArgs.typ); err != nil {
-				return fmt.Errorf("cannot correct: %v", err)
+			if err := correct(c, id, correctArgs.typ, cor); err != nil && !skipped.add(err) {
+				return correctError(err)
 			}
 		}
-		return nil
+		return skipped.err()
 	}
 	s := bufio.NewScanner(os.Stdin)
 	for s.Scan() {
@@ -51,14 +101,14 @@ func doCorrect(_ *cobra.Command, args []string) error {
 		}
 		id := line[:pos]
 		cor := line[pos+1:]
-		if err := correct(c, id, correctArgs.typ, cor); err != nil {
-			return fmt.Errorf("cannot correct: %v", err)
+		if err := correct(c, id, correctArgs.typ, cor); err != nil && !skipped.add(err) {
+			return correctError(err)
 		}
 	}
 	if err := s.Err(); err != nil {
 		return fmt.Errorf("cannot correct: %v", err)
 	}
-	return nil
+	return skipped.err()
 }
 
 func correct(c *api.Client, id, typ, correction string) error {
@@ -66,33 +116,206 @@ func correct(c *api.Client, id, typ, correction string) error {
 	if err != nil {
 		return fmt.Errorf("unqote %s: %v", correction, err)
 	}
-	var url string
-	var resp interface{}
-	var line api.Line
-	var token api.Token
-	var bid, pid, lid, wid, len int
-	switch n := parseIDs(id, &bid, &pid, &lid, &wid, &len); n {
+	var skipped skipCount
+	err = eachID(c, id, bookLevels, func(ids []int) error {
+		id := joinIDs(ids)
+		resp, err := applyCorrectionWith(c, id, typ, cor, correctOptions(nil, nil))
+		if _, ok := err.(skipError); ok {
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", id, err)
+			skipped++
+			return nil
+		}
+		if _, ok := err.(journalError); ok {
+			format(resp)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		format(resp)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return skipped.err()
+}
+
+// correctError returns the error of the correct command for the given
+// error.  Journal errors are returned as they are, since the
+// correction itself was applied.
+func correctError(err error) error {
+	if _, ok := err.(journalError); ok {
+		return err
+	}
+	return fmt.Errorf("cannot correct: %v", err)
+}
+
+// exitSkipped is the exit code of the correct command if any
+// correction was refused by --expect-cor, --expect-ocr or
+// --skip-manual.
+const exitSkipped = 4
+
+// skipCount counts the refused corrections.
+type skipCount int
+
+func (n skipCount) Error() string {
+	return fmt.Sprintf("%d correction(s) skipped", int(n))
+}
+
+// add adds the refused corrections of err to the count.  It returns
+// false if err does not report refused corrections.
+func (n *skipCount) add(err error) bool {
+	var m skipCount
+	if !errors.As(err, &m) {
+		return false
+	}
+	*n += m
+	return true
+}
+
+// err returns an exitError with the code exitSkipped if any
+// correction was refused and nil otherwise.
+func (n skipCount) err() error {
+	if n == 0 {
+		return nil
+	}
+	return exitError{code: exitSkipped, err: fmt.Errorf("cannot correct: %w", n)}
+}
+
+// correctOptions returns the correction options for the correct
+// command.  The given expected values overwrite --expect-cor and
+// --expect-ocr if they are not nil.
+func correctOptions(expectCor, expectOCR *string) correctionOptions {
+	if expectCor == nil && correctArgs.expectCorSet {
+		expectCor = &correctArgs.expectCor
+	}
+	if expectOCR == nil && correctArgs.expectOCRSet {
+		expectOCR = &correctArgs.expectOCR
+	}
+	return correctionOptions{
+		check: func(now correctionState) error {
+			if correctArgs.skipManual && now.IsManuallyCorrected {
+				return skipError("manually corrected: " + s(now.Cor))
+			}
+			if expectCor != nil && now.Cor != *expectCor {
+				return skipError(fmt.Sprintf("expected correction %q, found %q",
+					*expectCor, now.Cor))
+			}
+			if expectOCR != nil && now.OCR != *expectOCR {
+				return skipError(fmt.Sprintf("expected ocr %q, found %q",
+					*expectOCR, now.OCR))
+			}
+			return nil
+		},
+	}
+}
+
+// correctionOptions defines additional options for corrections.
+type correctionOptions struct {
+	// undoes is the time of the journal entry that is undone by
+	// the correction (if not 0).
+	undoes int64
+	// journal is the journal file the correction is recorded in.
+	// If empty, the default journal is used.
+	journal string
+	// check is called with the state of the line or token before
+	// the correction.  If it returns an error, the correction is
+	// not applied.
+	check func(correctionState) error
+	// replace, if set, computes the correction from the current
+	// text of the line or token.  It is called before check.
+	replace func(string) string
+}
+
+// skipError is returned for corrections that are refused by a
+// correction check.
+type skipError string
+
+func (err skipError) Error() string {
+	return string(err)
+}
+
+// applyCorrection corrects the line or token with the given ID.  It
+// returns the corrected *api.Line or *api.Token.  The states before
+// and after the correction are recorded in the journal.
+func applyCorrection(c *api.Client, id, typ, cor string) (interface{}, error) {
+	return applyCorrectionWith(c, id, typ, cor, correctionOptions{})
+}
+
+// applyCorrectionWith corrects the line or token with the given ID
+// using the given options.  If the correction is applied but cannot be
+// recorded in the journal, the corrected line or token is returned
+// together with a journalError.
+func applyCorrectionWith(c *api.Client, id, typ, cor string, opts correctionOptions) (interface{}, error) {
+	url, newResp, err := correctionURL(c, id)
+	if err != nil {
+		return nil, err
+	}
+	before := newResp()
+	if err := get(c, url, before); err != nil {
+		return nil, err
+	}
+	if opts.check != nil || opts.replace != nil {
+		var state correctionState
+		if err := convert(before, &state); err != nil {
+			return nil, err
+		}
+		if opts.replace != nil {
+			cor = opts.replace(state.Cor)
+		}
+		if opts.check != nil {
+			if err := opts.check(state); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	after := newResp()
+	err = put(c, url+sep+"t="+typ, struct {
+		Cor string `json:"correction"`
+	}{cor}, after)
+	if err != nil {
+		return nil, err
+	}
+	entry := journalEntry{
+		Host:   c.Host,
+		ID:     id,
+		Type:   typ,
+		Undoes: opts.undoes,
+		Before: before,
+		After:  after,
+	}
+	if err := entry.write(opts.journal); err != nil {
+		return after, journalError{id: id, err: err}
+	}
+	return after, nil
+}
+
+// correctionURL returns the url of the line or token with the given
+// ID and a function that allocates a new *api.Line or *api.Token
+// respectively.
+func correctionURL(c *api.Client, id string) (string, func() interface{}, error) {
+	newLine := func() interface{} { return new(api.Line) }
+	newToken := func() interface{} { return new(api.Token) }
+	ids, err := parseID(id, bookLevels)
+	if err != nil {
+		return "", nil, err
+	}
+	switch len(ids) {
 	case 3:
-		url = c.URL("books/%d/pages/%d/lines/%d?t=%s",
-			bid, pid, lid, typ)
-		resp = &line
+		return c.URL("books/%d/pages/%d/lines/%d",
+			ids[0], ids[1], ids[2]), newLine, nil
 	case 4:
-		url = c.URL("books/%d/pages/%d/lines/%d/tokens/%d?t=%s",
-			bid, pid, lid, wid, typ)
-		resp = &token
+		return c.URL("books/%d/pages/%d/lines/%d/tokens/%d",
+			ids[0], ids[1], ids[2], ids[3]), newToken, nil
 	case 5:
-		url = c.URL("books/%d/pages/%d/lines/%d/tokens/%d?t=%s&len=%d",
-			bid, pid, lid, wid, typ, len)
-		resp = &token
+		return c.URL("books/%d/pages/%d/lines/%d/tokens/%d?len=%d",
+			ids[0], ids[1], ids[2], ids[3], ids[4]), newToken, nil
 	default:
-		return fmt.Errorf("invalid id: %q", id)
-	}
-	err = c.Put(url, struct {
-		Cor string `json:"correction"`
-	}{cor}, resp)
-	if err != nil {
-		return err
+		return "", nil, fmt.Errorf("invalid id %q: missing line id", id)
 	}
-	format(resp)
-	return nil
 }
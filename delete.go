@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 
-	"github.com/finkf/pcwgo/api"
 	"github.com/spf13/cobra"
 )
 
@@ -20,21 +19,21 @@ var deleteBooksCommand = cobra.Command{
 }
 
 func deleteBooks(_ *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	for _, id := range args {
-		var bid, pid, lid int
-		var url string
-		switch n := parseIDs(id, &bid, &pid, &lid); n {
-		case 3:
-			url = c.URL("books/%d/pages/%d/lines/%d", bid, pid, lid)
-		case 2:
-			url = c.URL("books/%d/pages/%d", bid, pid)
-		case 1:
-			url = c.URL("books/%d", bid)
-		default:
-			return fmt.Errorf("delete book: invalid id: %q", id)
-		}
-		if err := delete(c, url, nil); err != nil {
+		err := eachID(c, id, bookLevels[:3], func(ids []int) error {
+			var url string
+			switch len(ids) {
+			case 3:
+				url = c.URL("books/%d/pages/%d/lines/%d", ids[0], ids[1], ids[2])
+			case 2:
+				url = c.URL("books/%d/pages/%d", ids[0], ids[1])
+			default:
+				url = c.URL("books/%d", ids[0])
+			}
+			return delete(c, url, nil)
+		})
+		if err != nil {
 			return fmt.Errorf("delete book %s: %v", id, err)
 		}
 	}
@@ -49,12 +48,13 @@ var deleteUsersCommand = cobra.Command{
 }
 
 func deleteUsers(_ *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	for _, id := range args {
-		var uid int
-		if n := parseIDs(id, &uid); n != 1 {
-			return fmt.Errorf("delete user: invalid user id: %s", id)
+		ids, err := parseID(id, userLevels)
+		if err != nil {
+			return fmt.Errorf("delete user: %v", err)
 		}
+		uid := ids[0]
 		if err := delete(c, c.URL("users/%d", uid), nil); err != nil {
 			return fmt.Errorf("delete user: %v", err)
 		}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/finkf/pcwgo/api"
+	"github.com/spf13/cobra"
+)
+
+var downloadArgs = struct {
+	out    string
+	global bool
+	user   bool
+}{}
+
+func init() {
+	downloadCommand.PersistentFlags().StringVarP(&downloadArgs.out, "out", "o",
+		"", "set output file (default: stdout)")
+	downloadPoolCommand.Flags().BoolVarP(&downloadArgs.global, "global", "g",
+		false, "download the global pool")
+	downloadPoolCommand.Flags().BoolVarP(&downloadArgs.user, "user", "u",
+		false, "download the pool of the logged in user (default)")
+}
+
+var downloadCommand = cobra.Command{
+	Use:   "download",
+	Short: "Download books and pools",
+}
+
+var downloadBookCommand = cobra.Command{
+	Use:   "book ID",
+	Short: "Download the archive of book ID",
+	Args:  cobra.ExactArgs(1),
+	RunE:  doDownloadBook,
+}
+
+func doDownloadBook(_ *cobra.Command, args []string) error {
+	ids, err := parseID(args[0], bookLevels[:1])
+	if err != nil {
+		return fmt.Errorf("download book: %v", err)
+	}
+	bid := ids[0]
+	c := authenticate()
+	if err := download(c, c.URL("books/%d/download", bid)); err != nil {
+		return fmt.Errorf("download book %d: %v", bid, err)
+	}
+	return nil
+}
+
+var downloadPoolCommand = cobra.Command{
+	Use:   "pool",
+	Short: "Download the correction pool",
+	Args:  cobra.NoArgs,
+	RunE:  doDownloadPool,
+	Long: `
+Download the correction pool.  If --global is given, the global pool
+of all corrections is downloaded.  Otherwise the pool of the logged in
+user is downloaded.`,
+}
+
+func doDownloadPool(_ *cobra.Command, args []string) error {
+	if downloadArgs.global && downloadArgs.user {
+		return fmt.Errorf("download pool: --global and --user are mutually exclusive")
+	}
+	pool := "user"
+	if downloadArgs.global {
+		pool = "global"
+	}
+	c := authenticate()
+	if err := download(c, c.URL("pool/%s", pool)); err != nil {
+		return fmt.Errorf("download %s pool: %v", pool, err)
+	}
+	return nil
+}
+
+func download(c *api.Client, url string) error {
+	if downloadArgs.out == "" {
+		return downloadZIP(c, url, os.Stdout)
+	}
+	out, err := os.Create(downloadArgs.out)
+	if err != nil {
+		return err
+	}
+	if err := downloadZIPAndClose(c, url, out); err != nil {
+		os.Remove(downloadArgs.out)
+		return err
+	}
+	return nil
+}
+
+func downloadZIPAndClose(c *api.Client, url string, out io.WriteCloser) error {
+	if err := downloadZIP(c, url, out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // register gif for imageSize
+	_ "image/jpeg" // register jpeg for imageSize
+	_ "image/png"  // register png for imageSize
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/finkf/pcwgo/api"
+	"github.com/spf13/cobra"
+)
+
+var exportArgs = struct {
+	format string
+	out    string
+	ocr    bool
+}{}
+
+func init() {
+	exportCommand.Flags().StringVarP(&exportArgs.format, "format", "f",
+		"txt", "set export format (page|alto|hocr|txt)")
+	exportCommand.PersistentFlags().StringVarP(&exportArgs.out, "out", "o",
+		".", "set output directory")
+	exportCommand.PersistentFlags().IntVarP(&pageArgs.jobs, "jobs", "j", 4,
+		"fetch up to N pages concurrently")
+	exportCommand.Flags().BoolVarP(&exportArgs.ocr, "ocr", "c", false,
+		"export ocr instead of corrected text")
+}
+
+var exportCommand = cobra.Command{
+	Use:   "export ID",
+	Short: "Export the pages of book ID",
+	Args:  cobra.ExactArgs(1),
+	RunE:  doExport,
+	Long: `
+Export the pages of book ID into the output directory.  One file is
+written for each page.  Use BOOK:PAGES to only export some pages (e.g.
+12:3-40).  Supported formats are PAGE XML (page), ALTO
+(alto), hOCR (hocr) and plain text (txt).  Coordinates of pages,
+lines and words are included if the format supports them.  For PAGE,
+ALTO and hOCR the size of the page image is read from the header of
+the image.  For unsupported image formats (e.g. TIFF) or if the image
+cannot be read, the extent of the page box is used instead.`,
+}
+
+// pageWriter writes a page in a specific export format.  If size is
+// set, the size of the page image is passed to write.
+type pageWriter struct {
+	ext   string
+	size  bool
+	write func(io.Writer, *api.Page, image.Point) error
+}
+
+var pageWriters = map[string]pageWriter{
+	"page": {".page.xml", true, writePAGE},
+	"alto": {".alto.xml", true, writeALTO},
+	"hocr": {".hocr", true, writeHOCR},
+	"txt":  {".txt", false, writeTXT},
+}
+
+func doExport(_ *cobra.Command, args []string) error {
+	pw, ok := pageWriters[exportArgs.format]
+	if !ok {
+		return fmt.Errorf("export: invalid format: %q", exportArgs.format)
+	}
+	if err := os.MkdirAll(exportArgs.out, 0755); err != nil {
+		return fmt.Errorf("export book %s: %v", args[0], err)
+	}
+	c := authenticate()
+	// The image sizes are fetched concurrently with the pages.
+	err := eachPageOf(c, args[0], func(p *api.Page) (func() error, error) {
+		var size image.Point
+		if pw.size {
+			size = pageSize(c, p)
+		}
+		return func() error { return exportPage(p, size, pw) }, nil
+	})
+	if err != nil {
+		return fmt.Errorf("export book %s: %v", args[0], err)
+	}
+	return nil
+}
+
+func exportPage(p *api.Page, size image.Point, pw pageWriter) error {
+	name := filepath.Join(exportArgs.out, pageFileName(p)+pw.ext)
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := pw.write(out, p, size); err != nil {
+		out.Close()
+		return fmt.Errorf("write %s: %v", name, err)
+	}
+	return out.Close()
+}
+
+// imageSize returns the size of the image of the given page.  Only
+// the header of the image is read.  The zero size is returned if the
+// page has no image or if the format of the image is not supported.
+func imageSize(c *api.Client, p *api.Page) (image.Point, error) {
+	if p.ImgFile == "" {
+		return image.Point{}, nil
+	}
+	r, w := io.Pipe()
+	go func() {
+		var err error
+		defer func() { w.CloseWithError(err) }()
+		defer recoverAbort(&err)
+		err = getRaw(c, imageURL(c, p.ImgFile), "image/", w)
+	}()
+	// Closing the reader stops the download of the rest of the image.
+	defer r.Close()
+	config, _, err := image.DecodeConfig(r)
+	if errors.Is(err, image.ErrFormat) {
+		return image.Point{}, nil
+	}
+	if err != nil {
+		return image.Point{}, err
+	}
+	return image.Pt(config.Width, config.Height), nil
+}
+
+// pageSize returns the size of the image of the given page.  If the
+// size of the image is not known, the extent of the page box is used.
+// Errors reading the image are logged as warnings.
+func pageSize(c *api.Client, p *api.Page) image.Point {
+	size, err := imageSize(c, p)
+	if err != nil {
+		log.Printf("warning: page %d: image %s: %v", p.PageID, p.ImgFile, err)
+	}
+	if size == (image.Point{}) && hasBox(p.Box) {
+		size = image.Pt(p.Box.Right, p.Box.Bottom)
+	}
+	return size
+}
+
+// imageURL returns the url of an image file of the pocoweb instance.
+// Absolute image urls are returned as they are.  Requests to other
+// hosts are sent without authorization (see doRequest).
+func imageURL(c *api.Client, img string) string {
+	if strings.HasPrefix(img, "http://") || strings.HasPrefix(img, "https://") {
+		return img
+	}
+	return strings.TrimRight(c.Host, "/") + "/" + strings.TrimLeft(img, "/")
+}
+
+func pageFileName(p *api.Page) string {
+	return fmt.Sprintf("%d-%04d", p.ProjectID, p.PageID)
+}
+
+func lineText(line *api.Line) string {
+	if exportArgs.ocr {
+		return line.OCR
+	}
+	return line.Cor
+}
+
+func tokenText(token *api.Token) string {
+	if exportArgs.ocr {
+		return token.OCR
+	}
+	return token.Cor
+}
+
+func hasBox(b api.Box) bool {
+	return b.Right > b.Left && b.Bottom > b.Top
+}
+
+func writeTXT(out io.Writer, p *api.Page, _ image.Point) error {
+	for i := range p.Lines {
+		if _, err := fmt.Fprintln(out, lineText(&p.Lines[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeXML(out io.Writer, data interface{}) error {
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(out)
+	e.Indent("", "\t")
+	if err := e.Encode(data); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, "\n")
+	return err
+}
+
+// PAGE XML
+type pagePcGts struct {
+	XMLName  xml.Name     `xml:"PcGts"`
+	XMLNS    string       `xml:"xmlns,attr"`
+	Metadata pageMetadata `xml:"Metadata"`
+	Page     pagePage     `xml:"Page"`
+}
+
+type pageMetadata struct {
+	Creator    string `xml:"Creator"`
+	Created    string `xml:"Created"`
+	LastChange string `xml:"LastChange"`
+}
+
+type pagePage struct {
+	ImageFilename string          `xml:"imageFilename,attr"`
+	ImageWidth    int             `xml:"imageWidth,attr,omitempty"`
+	ImageHeight   int             `xml:"imageHeight,attr,omitempty"`
+	Region        *pageTextRegion `xml:"TextRegion"`
+}
+
+type pageTextRegion struct {
+	ID        string         `xml:"id,attr"`
+	Coords    pageCoords     `xml:"Coords"`
+	TextLines []pageTextLine `xml:"TextLine"`
+}
+
+type pageTextLine struct {
+	ID        string        `xml:"id,attr"`
+	Coords    pageCoords    `xml:"Coords"`
+	Words     []pageWord    `xml:"Word"`
+	TextEquiv pageTextEquiv `xml:"TextEquiv"`
+}
+
+type pageWord struct {
+	ID        string        `xml:"id,attr"`
+	Coords    pageCoords    `xml:"Coords"`
+	TextEquiv pageTextEquiv `xml:"TextEquiv"`
+}
+
+type pageCoords struct {
+	Points string `xml:"points,attr"`
+}
+
+type pageTextEquiv struct {
+	Unicode string `xml:"Unicode"`
+}
+
+func pagePoints(b api.Box) pageCoords {
+	return pageCoords{fmt.Sprintf("%d,%d %d,%d %d,%d %d,%d",
+		b.Left, b.Top, b.Right, b.Top, b.Right, b.Bottom, b.Left, b.Bottom)}
+}
+
+// writePAGE writes the page as PAGE XML.  Coords are required in
+// PAGE, so lines and words without a box are skipped.  The text
+// region spans the page box or, if the page has no box, the boxes of
+// its lines.  It is omitted if no line has a box.
+func writePAGE(out io.Writer, p *api.Page, size image.Point) error {
+	now := time.Now().Format("2006-01-02T15:04:05")
+	doc := pagePcGts{
+		XMLNS:    "http://schema.primaresearch.org/PAGE/gts/pagecontent/2019-07-15",
+		Metadata: pageMetadata{"pcwclient", now, now},
+		Page: pagePage{
+			ImageFilename: filepath.Base(p.ImgFile),
+			ImageWidth:    size.X,
+			ImageHeight:   size.Y,
+		},
+	}
+	box := p.Box
+	var lines []pageTextLine
+	for i := range p.Lines {
+		line := &p.Lines[i]
+		if !hasBox(line.Box) {
+			continue
+		}
+		if !hasBox(p.Box) {
+			box = unionBox(box, line.Box)
+		}
+		tl := pageTextLine{
+			ID:        fmt.Sprintf("l%d", line.LineID),
+			Coords:    pagePoints(line.Box),
+			TextEquiv: pageTextEquiv{lineText(line)},
+		}
+		for j := range line.Tokens {
+			token := &line.Tokens[j]
+			if !hasBox(token.Box) {
+				continue
+			}
+			tl.Words = append(tl.Words, pageWord{
+				ID:        fmt.Sprintf("l%dw%d", line.LineID, token.TokenID),
+				Coords:    pagePoints(token.Box),
+				TextEquiv: pageTextEquiv{tokenText(token)},
+			})
+		}
+		lines = append(lines, tl)
+	}
+	if hasBox(box) {
+		doc.Page.Region = &pageTextRegion{
+			ID:        "r1",
+			Coords:    pagePoints(box),
+			TextLines: lines,
+		}
+	}
+	return writeXML(out, doc)
+}
+
+// unionBox returns the smallest box containing a and b.  Empty boxes
+// are ignored.
+func unionBox(a, b api.Box) api.Box {
+	if !hasBox(a) {
+		return b
+	}
+	if !hasBox(b) {
+		return a
+	}
+	if b.Left < a.Left {
+		a.Left = b.Left
+	}
+	if b.Top < a.Top {
+		a.Top = b.Top
+	}
+	if b.Right > a.Right {
+		a.Right = b.Right
+	}
+	if b.Bottom > a.Bottom {
+		a.Bottom = b.Bottom
+	}
+	a.Width, a.Height = a.Right-a.Left, a.Bottom-a.Top
+	return a
+}
+
+// ALTO
+type altoDoc struct {
+	XMLName xml.Name `xml:"alto"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Page    altoPage `xml:"Layout>Page"`
+}
+
+type altoPage struct {
+	ID     string        `xml:"ID,attr"`
+	Width  int           `xml:"WIDTH,attr,omitempty"`
+	Height int           `xml:"HEIGHT,attr,omitempty"`
+	Block  altoTextBlock `xml:"PrintSpace>TextBlock"`
+}
+
+type altoTextBlock struct {
+	ID    string         `xml:"ID,attr"`
+	Lines []altoTextLine `xml:"TextLine"`
+}
+
+type altoBox struct {
+	HPos   int `xml:"HPOS,attr"`
+	VPos   int `xml:"VPOS,attr"`
+	Width  int `xml:"WIDTH,attr"`
+	Height int `xml:"HEIGHT,attr"`
+}
+
+type altoTextLine struct {
+	ID string `xml:"ID,attr"`
+	*altoBox
+	Strings []altoString `xml:"String"`
+}
+
+type altoString struct {
+	ID      string `xml:"ID,attr"`
+	Content string `xml:"CONTENT,attr"`
+	*altoBox
+}
+
+func newALTOBox(b api.Box) *altoBox {
+	if !hasBox(b) {
+		return nil
+	}
+	return &altoBox{
+		HPos:   b.Left,
+		VPos:   b.Top,
+		Width:  b.Right - b.Left,
+		Height: b.Bottom - b.Top,
+	}
+}
+
+func writeALTO(out io.Writer, p *api.Page, size image.Point) error {
+	doc := altoDoc{
+		XMLNS: "http://www.loc.gov/standards/alto/ns-v4#",
+		Page: altoPage{
+			ID:     fmt.Sprintf("p%d", p.PageID),
+			Width:  size.X,
+			Height: size.Y,
+			Block:  altoTextBlock{ID: "b1"},
+		},
+	}
+	for i := range p.Lines {
+		line := &p.Lines[i]
+		tl := altoTextLine{
+			ID:      fmt.Sprintf("l%d", line.LineID),
+			altoBox: newALTOBox(line.Box),
+		}
+		for j := range line.Tokens {
+			token := &line.Tokens[j]
+			tl.Strings = append(tl.Strings, altoString{
+				ID:      fmt.Sprintf("l%dw%d", line.LineID, token.TokenID),
+				Content: tokenText(token),
+				altoBox: newALTOBox(token.Box),
+			})
+		}
+		doc.Page.Block.Lines = append(doc.Page.Block.Lines, tl)
+	}
+	return writeXML(out, doc)
+}
+
+// hOCR
+type hocrHTML struct {
+	XMLName xml.Name  `xml:"html"`
+	XMLNS   string    `xml:"xmlns,attr"`
+	Meta    []hocrTag `xml:"head>meta"`
+	Page    hocrSpan  `xml:"body>div"`
+}
+
+type hocrTag struct {
+	Name    string `xml:"name,attr"`
+	Content string `xml:"content,attr"`
+}
+
+type hocrSpan struct {
+	Class string     `xml:"class,attr"`
+	ID    string     `xml:"id,attr"`
+	Title string     `xml:"title,attr,omitempty"`
+	Text  string     `xml:",chardata"`
+	Spans []hocrSpan `xml:"span"`
+}
+
+func hocrTitle(b api.Box, extra ...string) string {
+	if hasBox(b) {
+		extra = append(extra, fmt.Sprintf("bbox %d %d %d %d",
+			b.Left, b.Top, b.Right, b.Bottom))
+	}
+	return strings.Join(extra, "; ")
+}
+
+func writeHOCR(out io.Writer, p *api.Page, size image.Point) error {
+	// The bounding box of the page is the whole image.
+	var box api.Box
+	if size.X > 0 && size.Y > 0 {
+		box = api.Box{Right: size.X, Bottom: size.Y}
+	}
+	doc := hocrHTML{
+		XMLNS: "http://www.w3.org/1999/xhtml",
+		Meta: []hocrTag{
+			{"ocr-system", "pcwclient"},
+			{"ocr-capabilities", "ocr_page ocr_line ocrx_word"},
+		},
+		Page: hocrSpan{
+			Class: "ocr_page",
+			ID:    fmt.Sprintf("page_%d", p.PageID),
+			Title: hocrTitle(box, fmt.Sprintf("image %q", filepath.Base(p.ImgFile))),
+		},
+	}
+	for i := range p.Lines {
+		line := &p.Lines[i]
+		hl := hocrSpan{
+			Class: "ocr_line",
+			ID:    fmt.Sprintf("line_%d", line.LineID),
+			Title: hocrTitle(line.Box),
+		}
+		for j := range line.Tokens {
+			token := &line.Tokens[j]
+			hl.Spans = append(hl.Spans, hocrSpan{
+				Class: "ocrx_word",
+				ID:    fmt.Sprintf("word_%d_%d", line.LineID, token.TokenID),
+				Title: hocrTitle(token.Box),
+				Text:  tokenText(token),
+			})
+		}
+		doc.Page.Spans = append(doc.Page.Spans, hl)
+	}
+	return writeXML(out, doc)
+}
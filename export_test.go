@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/finkf/pcwgo/api"
+)
+
+func TestWritePAGE(t *testing.T) {
+	box := func(l, t, r, b int) api.Box {
+		return api.Box{Left: l, Top: t, Right: r, Bottom: b, Width: r - l, Height: b - t}
+	}
+	line := func(id int, b api.Box, tokens ...api.Token) api.Line {
+		return api.Line{LineID: id, Box: b, Tokens: tokens}
+	}
+	token := func(id int, b api.Box) api.Token {
+		return api.Token{TokenID: id, Box: b}
+	}
+	for _, tc := range []struct {
+		name      string
+		page      api.Page
+		want, not []string
+	}{
+		{"page box", api.Page{Box: box(1, 2, 30, 40), Lines: []api.Line{
+			line(1, box(1, 2, 30, 10), token(1, box(1, 2, 10, 10))),
+		}}, []string{`points="1,2 30,2 30,40 1,40"`, `id="l1"`, `id="l1w1"`}, nil},
+		{"line boxes", api.Page{Lines: []api.Line{
+			line(1, box(5, 2, 30, 10)),
+			line(2, box(1, 12, 20, 20)),
+		}}, []string{`points="1,2 30,2 30,20 1,20"`, `id="l1"`, `id="l2"`}, nil},
+		{"empty boxes", api.Page{Box: box(1, 2, 30, 40), Lines: []api.Line{
+			line(1, api.Box{}, token(1, api.Box{})),
+			line(2, box(1, 12, 20, 20), token(1, api.Box{}), token(2, box(1, 12, 5, 20))),
+		}}, []string{`id="l2"`, `id="l2w2"`}, []string{`id="l1"`, `id="l2w1"`, `"0,0 0,0 0,0 0,0"`}},
+		{"no boxes", api.Page{Lines: []api.Line{line(1, api.Box{})}},
+			nil, []string{"TextRegion", "Coords"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writePAGE(&buf, &tc.page, image.Point{}); err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("expected %s in:\n%s", want, buf.String())
+				}
+			}
+			for _, not := range tc.not {
+				if strings.Contains(buf.String(), not) {
+					t.Errorf("unexpected %s in:\n%s", not, buf.String())
+				}
+			}
+		})
+	}
+}
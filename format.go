@@ -3,7 +3,6 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"strings"
 	"text/template"
@@ -64,8 +63,25 @@ func format(data interface{}) {
 		formatBooks(t)
 	case *api.Book:
 		formatBook(t)
+	case *config:
+		formatConfig(t)
+	case *validationReport:
+		formatValidationReport(t)
+	case *api.JobStatus:
+		printf(nil, "%d %d %s %s %s\n", t.JobID, t.BookID, t.JobName,
+			t.StatusName, t.Time().Format(time.RFC3339))
+	case *startReport:
+		formatStartReport(t)
+	case *applySummary:
+		printf(nil, "%d %d %d %d %d\n", t.BookID, t.Applied, t.WouldApply,
+			t.Skipped, t.Conflicts)
+	case *suggestionMatch:
+		printf(nil, "%s %s %s %d\n", t.Word, t.Suggestion,
+			patterns(t.OCRPatterns), t.Count)
+	case *replacement:
+		printf(nil, "%s %s %s\n", t.ID, t.Old, t.New)
 	default:
-		log.Fatalf("error: invalid type to print: %T", t)
+		chk(fmt.Errorf("invalid type to print: %T", t))
 	}
 }
 
@@ -174,8 +190,9 @@ func formatCharMap(chars *api.CharMap) {
 }
 
 func formatPostCorrection(pcs *api.PostCorrection) {
-	for _, pc := range pcs.Corrections {
-		fmt.Printf("%d:%d:%d:%d %s %s %f %t\n",
+	for _, key := range sortedKeys(pcs.Corrections) {
+		pc := pcs.Corrections[key]
+		printf(nil, "%d:%d:%d:%d %s %s %f %t\n",
 			pcs.BookID, pc.PageID, pc.LineID, pc.TokenID,
 			pc.OCR, pc.Cor, pc.Confidence, pc.Taken)
 	}
@@ -244,27 +261,27 @@ func formatExtendedLexicon(lex *api.ExtendedLexicon) {
 }
 
 func formatProfile(profile gofiler.Profile) {
-	pats := func(pats []gofiler.Pattern) []string {
-		var ret []string
-		for _, pat := range pats {
-			ret = append(ret, fmt.Sprintf("%s:%s:%d",
-				pat.Left, pat.Right, pat.Pos))
-		}
-		return ret
-	}
 	for k, v := range profile {
 		top := true
 		for _, c := range v.Candidates {
 			printf(nil, "%s %s %s %s %s %s %d %f %t\n",
 				k, c.Suggestion, c.Modern,
-				strings.Join(pats(c.HistPatterns), ","),
-				strings.Join(pats(c.OCRPatterns), ","),
+				strings.Join(profilePatterns(c.HistPatterns), ","),
+				strings.Join(profilePatterns(c.OCRPatterns), ","),
 				c.Dict, c.Distance, c.Weight, top)
 			top = false
 		}
 	}
 }
 
+func profilePatterns(pats []gofiler.Pattern) patterns {
+	var ret patterns
+	for _, pat := range pats {
+		ret = append(ret, fmt.Sprintf("%s:%s:%d", pat.Left, pat.Right, pat.Pos))
+	}
+	return ret
+}
+
 func formatSession(s api.Session) {
 	printf(nil, "%d %s %s %t %s %s\n",
 		s.User.ID, s.User.Email, s.User.Name, s.User.Admin,
@@ -299,6 +316,36 @@ func formatBook(book *api.Book) {
 		book.Year, book.Language, book.ProfilerURL, book.Description)
 }
 
+func formatConfig(cfg *config) {
+	for _, name := range cfg.names() {
+		p := cfg.Profiles[name]
+		printf(nil, "%s %s %t %t %t\n", name, p.URL, p.SkipVerify,
+			p.Token != "", name == cfg.Default)
+	}
+}
+
+func formatValidationReport(report *validationReport) {
+	var formats []string
+	for _, format := range sortedKeys(report.Formats) {
+		formats = append(formats, fmt.Sprintf("%s:%d", format, report.Formats[format]))
+	}
+	printf(nil, "%s %s %d %d\n", report.Path, patterns(formats),
+		report.Images, len(report.Problems))
+	for _, p := range report.Problems {
+		printf(nil, "%s\n", p)
+	}
+}
+
+func formatStartReport(report *startReport) {
+	for _, res := range report.Results {
+		printf(nil, "%d %s %s %s", res.BookID, res.Job, res.Status, res.Duration)
+		if res.Error != "" {
+			printf(nil, " %s", res.Error)
+		}
+		printf(nil, "\n")
+	}
+}
+
 func bookStatusString(book *api.Book) string {
 	res := []byte("---")
 	if book.Status["profiled"] {
@@ -320,6 +367,9 @@ func formatMaybeSpecial(data interface{}) bool {
 	if formatMaybeTemplate(data) {
 		return true
 	}
+	if formatMaybeOutput(data) {
+		return true
+	}
 	return false
 }
 
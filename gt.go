@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/UNO-SOFT/ulog"
+	"github.com/finkf/pcwgo/api"
+	"github.com/spf13/cobra"
+)
+
+var exportGTArgs = struct {
+	ocr bool
+}{}
+
+func init() {
+	exportGTCommand.Flags().BoolVarP(&exportGTArgs.ocr, "ocr", "c", false,
+		"additionally write the ocr text of the lines")
+}
+
+var exportGTCommand = cobra.Command{
+	Use:   "gt ID",
+	Short: "Export ground-truth lines of book ID",
+	Args:  cobra.ExactArgs(1),
+	RunE:  doExportGT,
+	Long: `
+Export ground-truth data for OCR training.  For each manually
+corrected line of book ID the line image and its corrected text
+(.gt.txt) are written into the output directory.  With --ocr the ocr
+text of the line (.ocr.txt) is written, too.  The file manifest.tsv
+maps the line IDs (book:page:line) to the line images and the
+ground-truth files.`,
+}
+
+func doExportGT(_ *cobra.Command, args []string) error {
+	if err := os.MkdirAll(exportArgs.out, 0755); err != nil {
+		return fmt.Errorf("export gt for book %s: %v", args[0], err)
+	}
+	manifest, err := os.Create(filepath.Join(exportArgs.out, "manifest.tsv"))
+	if err != nil {
+		return fmt.Errorf("export gt for book %s: %v", args[0], err)
+	}
+	defer manifest.Close()
+	c := authenticate()
+	err = eachPageOf(c, args[0], inOrder(func(p *api.Page) error {
+		for i := range p.Lines {
+			if !p.Lines[i].IsManuallyCorrected {
+				continue
+			}
+			img, err := exportGTLine(c, &p.Lines[i])
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(manifest, "%s\t%s\t%s\n", p.Lines[i].ID(),
+				img, gtStem(&p.Lines[i])+".gt.txt")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	if err != nil {
+		return fmt.Errorf("export gt for book %s: %v", args[0], err)
+	}
+	return manifest.Close()
+}
+
+func gtStem(line *api.Line) string {
+	return fmt.Sprintf("%d-%04d-%04d", line.ProjectID, line.PageID, line.LineID)
+}
+
+// exportGTLine writes the image and text files of the given line and
+// returns the file name of the line image.  The text files are only
+// written if the line image could be downloaded.
+func exportGTLine(c *api.Client, line *api.Line) (string, error) {
+	if line.ImgFile == "" {
+		return "", fmt.Errorf("line %s: missing line image", line.ID())
+	}
+	base := filepath.Join(exportArgs.out, gtStem(line))
+	img := base + path.Ext(line.ImgFile)
+	out, err := os.Create(img)
+	if err != nil {
+		return "", err
+	}
+	url := imageURL(c, line.ImgFile)
+	ulog.Write("download image", "url", url)
+	if err := getRaw(c, url, "image/", out); err != nil {
+		out.Close()
+		os.Remove(img)
+		return "", fmt.Errorf("line %s: get image: %v", line.ID(), err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(img)
+		return "", err
+	}
+	if err := writeFile(base+".gt.txt", line.Cor+"\n"); err != nil {
+		return "", err
+	}
+	if exportGTArgs.ocr {
+		if err := writeFile(base+".ocr.txt", line.OCR+"\n"); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Base(img), nil
+}
+
+func writeFile(name, content string) error {
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := out.WriteString(content); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/finkf/pcwgo/api"
+)
+
+// Levels of the different ID patterns.  The levels of an ID pattern
+// are separated by colons.
+var (
+	bookLevels    = []string{"book", "page", "line", "word", "length"}
+	userLevels    = []string{"user"}
+	packageLevels = []string{"package"}
+)
+
+// Special values of ID items.
+const (
+	idFirst = -1
+	idLast  = -2
+)
+
+// idItem is a single id or a range of ids of an ID pattern component.
+type idItem struct {
+	from, to int
+}
+
+func (item idItem) String() string {
+	str := func(id int) string {
+		switch id {
+		case idFirst:
+			return "first"
+		case idLast:
+			return "last"
+		default:
+			return strconv.Itoa(id)
+		}
+	}
+	if item.from == item.to {
+		return str(item.from)
+	}
+	return str(item.from) + "-" + str(item.to)
+}
+
+// idComponent is a component of an ID pattern.  It either matches all
+// IDs (*) or a list of IDs and ID ranges.
+type idComponent struct {
+	all   bool
+	items []idItem
+}
+
+// parseIDPattern parses an ID pattern with at most len(levels)
+// components.  Each component is either * or a comma separated list
+// of IDs, ID ranges (3-40) and the keywords first and last.
+func parseIDPattern(id string, levels []string) ([]idComponent, error) {
+	split := strings.Split(id, ":")
+	if len(split) > len(levels) {
+		return nil, fmt.Errorf("invalid id %q: too many components (at most %d: %s)",
+			id, len(levels), strings.Join(levels, ":"))
+	}
+	comps := make([]idComponent, len(split))
+	for i, str := range split {
+		comp, err := parseIDComponent(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q: invalid %s %q: %v",
+				id, levels[i], str, err)
+		}
+		comps[i] = comp
+	}
+	return comps, nil
+}
+
+// parseID parses a single ID with at most len(levels) components.
+// Ranges, lists, wildcards and the first and last keywords are not
+// allowed.
+func parseID(id string, levels []string) ([]int, error) {
+	comps, err := parseIDPattern(id, levels)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(comps))
+	for i, comp := range comps {
+		if comp.all || len(comp.items) != 1 ||
+			comp.items[0].from != comp.items[0].to || comp.items[0].from < 0 {
+			return nil, fmt.Errorf("invalid id %q: invalid %s %q: not a single id",
+				id, levels[i], strings.Split(id, ":")[i])
+		}
+		ids[i] = comp.items[0].from
+	}
+	return ids, nil
+}
+
+func parseIDComponent(str string) (idComponent, error) {
+	if str == "*" {
+		return idComponent{all: true}, nil
+	}
+	var comp idComponent
+	for _, item := range strings.Split(str, ",") {
+		from, to := item, item
+		if pos := strings.Index(item, "-"); pos != -1 {
+			from, to = item[:pos], item[pos+1:]
+		}
+		f, err := parseIDItem(from)
+		if err != nil {
+			return comp, err
+		}
+		t, err := parseIDItem(to)
+		if err != nil {
+			return comp, err
+		}
+		if f > 0 && t > 0 && f > t {
+			return comp, fmt.Errorf("invalid range %s", item)
+		}
+		comp.items = append(comp.items, idItem{from: f, to: t})
+	}
+	return comp, nil
+}
+
+func parseIDItem(str string) (int, error) {
+	switch str {
+	case "first":
+		return idFirst, nil
+	case "last":
+		return idLast, nil
+	case "":
+		return 0, fmt.Errorf("missing id")
+	}
+	id, err := strconv.Atoi(str)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("not a positive number")
+	}
+	return id, nil
+}
+
+// eachID expands the ID pattern and calls fn for each matching ID in
+// order.  Wildcards and the first and last keywords are resolved
+// using the server.
+func eachID(c *api.Client, id string, levels []string, fn func([]int) error) error {
+	comps, err := parseIDPattern(id, levels)
+	if err != nil {
+		return err
+	}
+	var expand func([]int) error
+	expand = func(prefix []int) error {
+		level := len(prefix)
+		if level == len(comps) {
+			return fn(prefix)
+		}
+		ids, err := expandIDComponent(c, comps[level], levels[level], prefix)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := expand(append(prefix[:level:level], id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return expand(nil)
+}
+
+func expandIDComponent(c *api.Client, comp idComponent, level string, prefix []int) ([]int, error) {
+	var known []int
+	if comp.all || comp.needsIDs() {
+		var err error
+		if known, err = listIDs(c, level, prefix); err != nil {
+			return nil, err
+		}
+		if len(known) == 0 {
+			return nil, nil
+		}
+	}
+	if comp.all {
+		return known, nil
+	}
+	resolve := func(id int) int {
+		switch id {
+		case idFirst:
+			return known[0]
+		case idLast:
+			return known[len(known)-1]
+		default:
+			return id
+		}
+	}
+	var ids []int
+	for _, item := range comp.items {
+		from, to := resolve(item.from), resolve(item.to)
+		if from > to {
+			return nil, fmt.Errorf("invalid %s range %s: %d > %d", level, item, from, to)
+		}
+		if from == to {
+			ids = append(ids, from)
+			continue
+		}
+		// IDs are not dense, so ranges only match existing IDs.
+		for _, id := range known {
+			if from <= id && id <= to {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// needsIDs returns true if the available IDs are needed to expand the
+// component.  This is the case for ranges and the first and last
+// keywords.
+func (comp idComponent) needsIDs() bool {
+	for _, item := range comp.items {
+		if item.from < 0 || item.to < 0 || item.from != item.to {
+			return true
+		}
+	}
+	return false
+}
+
+// listIDs returns the available IDs on the given level.
+func listIDs(c *api.Client, level string, prefix []int) ([]int, error) {
+	var ids []int
+	switch level {
+	case "user":
+		var users api.Users
+		if err := get(c, c.URL("users"), &users); err != nil {
+			return nil, err
+		}
+		for _, user := range users.Users {
+			ids = append(ids, int(user.ID))
+		}
+	case "book":
+		var books api.Books
+		if err := get(c, c.URL("books"), &books); err != nil {
+			return nil, err
+		}
+		for _, book := range books.Books {
+			ids = append(ids, book.ProjectID)
+		}
+	case "page":
+		var book api.Book
+		if err := get(c, c.URL("books/%d", prefix[0]), &book); err != nil {
+			return nil, err
+		}
+		ids = book.PageIDs
+	case "line":
+		page, err := fetchPage(c, prefix[0], prefix[1], 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range page.Lines {
+			ids = append(ids, line.LineID)
+		}
+	case "word":
+		var line api.Line
+		url := c.URL("books/%d/pages/%d/lines/%d", prefix[0], prefix[1], prefix[2])
+		if err := get(c, url, &line); err != nil {
+			return nil, err
+		}
+		for _, token := range line.Tokens {
+			ids = append(ids, token.TokenID)
+		}
+	default:
+		return nil, fmt.Errorf("cannot expand %s ids", level)
+	}
+	return ids, nil
+}
+
+// joinIDs joins the given IDs with colons.
+func joinIDs(ids []int) string {
+	strs := make([]string, len(ids))
+	for i := range ids {
+		strs[i] = strconv.Itoa(ids[i])
+	}
+	return strings.Join(strs, ":")
+}
+
+// eachPageOf calls fn for each page of the given book or page IDs
+// (see eachPage).
+func eachPageOf(c *api.Client, id string, fn pageFunc) error {
+	return eachID(c, id, bookLevels[:2], func(ids []int) error {
+		if len(ids) == 1 {
+			return eachPage(c, ids[0], fn)
+		}
+		p, err := fetchPage(c, ids[0], ids[1], 0)
+		if err != nil {
+			return err
+		}
+		then, err := fn(p)
+		if err != nil {
+			return err
+		}
+		return then()
+	})
+}
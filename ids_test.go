@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/finkf/pcwgo/api"
+)
+
+// newIDTestServer returns a server with the books 1 and 3.  Book 1
+// has the pages 2, 3 and 7; each page has the lines 1 and 4.
+func newIDTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var bid, pid int
+		var data interface{}
+		switch path := r.URL.Path; {
+		case path == "/rest/books":
+			data = api.Books{Books: []api.Book{{ProjectID: 1}, {ProjectID: 3}}}
+		case path == "/rest/books/1":
+			data = api.Book{ProjectID: 1, PageIDs: []int{2, 3, 7}}
+		case path == "/rest/books/3":
+			data = api.Book{ProjectID: 3}
+		default:
+			if n, _ := fmt.Sscanf(path, "/rest/books/%d/pages/%d", &bid, &pid); n != 2 {
+				http.NotFound(w, r)
+				return
+			}
+			data = api.Page{ProjectID: bid, PageID: pid, Lines: []api.Line{
+				{ProjectID: bid, PageID: pid, LineID: 1},
+				{ProjectID: bid, PageID: pid, LineID: 4},
+			}}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(data); err != nil {
+			t.Errorf("encode %s: %v", r.URL, err)
+		}
+	}))
+}
+
+func TestParseID(t *testing.T) {
+	for _, tc := range []struct {
+		id   string
+		want []int
+		err  string
+	}{
+		{"1", []int{1}, ""},
+		{"1:2:3", []int{1, 2, 3}, ""},
+		{"1:2:3:4:5", []int{1, 2, 3, 4, 5}, ""},
+		{"1:2:3:4:5:6", nil, "too many components"},
+		{"1:2-3", nil, `invalid page "2-3": not a single id`},
+		{"1,2", nil, `invalid book "1,2": not a single id`},
+		{"*", nil, `invalid book "*": not a single id`},
+		{"1:last", nil, `invalid page "last": not a single id`},
+		{"1:", nil, `invalid page "": missing id`},
+		{"0", nil, `invalid book "0": not a positive number`},
+		{"a", nil, `invalid book "a": not a positive number`},
+		{"3-1", nil, "invalid range 3-1"},
+	} {
+		t.Run(tc.id, func(t *testing.T) {
+			got, err := parseID(tc.id, bookLevels)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q; got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %v; got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExpandIDComponent(t *testing.T) {
+	srv := newIDTestServer(t)
+	defer srv.Close()
+	c := api.Authenticate(srv.URL, "", false)
+	for _, tc := range []struct {
+		comp, level string
+		prefix      []int
+		want        []int
+	}{
+		{"*", "book", nil, []int{1, 3}},
+		{"3", "book", nil, []int{3}},
+		{"1,3", "book", nil, []int{1, 3}},
+		{"4", "book", nil, []int{4}},
+		{"*", "page", []int{1}, []int{2, 3, 7}},
+		{"first", "page", []int{1}, []int{2}},
+		{"last", "page", []int{1}, []int{7}},
+		{"first-last", "page", []int{1}, []int{2, 3, 7}},
+		{"1-5", "page", []int{1}, []int{2, 3}},
+		{"3-last", "page", []int{1}, []int{3, 7}},
+		{"4-6", "page", []int{1}, nil},
+		{"2,7", "page", []int{1}, []int{2, 7}},
+		{"last,2-3", "page", []int{1}, []int{7, 2, 3}},
+		{"*", "page", []int{3}, nil},
+		{"first", "page", []int{3}, nil},
+		{"*", "line", []int{1, 2}, []int{1, 4}},
+		{"2-4", "line", []int{1, 2}, []int{4}},
+		{"last-first", "page", []int{3}, nil},
+	} {
+		t.Run(tc.level+" "+tc.comp, func(t *testing.T) {
+			comp, err := parseIDComponent(tc.comp)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			got, err := expandIDComponent(c, comp, tc.level, tc.prefix)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %v; got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExpandIDComponentReversedRange(t *testing.T) {
+	srv := newIDTestServer(t)
+	defer srv.Close()
+	c := api.Authenticate(srv.URL, "", false)
+	for _, tc := range []struct {
+		comp, err string
+	}{
+		{"last-first", "invalid page range last-first: 7 > 2"},
+		{"5-first", "invalid page range 5-first: 5 > 2"},
+		{"last-3", "invalid page range last-3: 7 > 3"},
+		{"2,last-first", "invalid page range last-first"},
+	} {
+		t.Run(tc.comp, func(t *testing.T) {
+			comp, err := parseIDComponent(tc.comp)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			_, err = expandIDComponent(c, comp, "page", []int{1})
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error %q; got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestExpandIDComponentWithoutServer(t *testing.T) {
+	// Single ids and lists of single ids do not need the server.
+	comp, err := parseIDComponent("3,1")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	got, err := expandIDComponent(nil, comp, "page", []int{1})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if want := []int{3, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v; got %v", want, got)
+	}
+}
+
+func TestEachID(t *testing.T) {
+	srv := newIDTestServer(t)
+	defer srv.Close()
+	c := api.Authenticate(srv.URL, "", false)
+	for _, tc := range []struct {
+		id   string
+		want []string
+	}{
+		{"1", []string{"1"}},
+		{"*", []string{"1", "3"}},
+		{"1:*", []string{"1:2", "1:3", "1:7"}},
+		{"*:*", []string{"1:2", "1:3", "1:7"}},
+		{"1:first,last", []string{"1:2", "1:7"}},
+		{"1:3-9", []string{"1:3", "1:7"}},
+		{"1:2-3:*", []string{"1:2:1", "1:2:4", "1:3:1", "1:3:4"}},
+		{"1:7:4:2", []string{"1:7:4:2"}},
+	} {
+		t.Run(tc.id, func(t *testing.T) {
+			var got []string
+			err := eachID(c, tc.id, bookLevels, func(ids []int) error {
+				got = append(got, joinIDs(ids))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %v; got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/finkf/pcwgo/api"
+	"github.com/finkf/pcwgo/db"
+	"github.com/spf13/cobra"
+)
+
+var jobArgs = struct {
+	sleep   int
+	timeout time.Duration
+}{}
+
+func init() {
+	jobCommand.PersistentFlags().IntVarP(&jobArgs.sleep, "sleep", "s", 5,
+		"set the maximal number of seconds to sleep between status checks")
+	jobWaitCommand.Flags().DurationVar(&jobArgs.timeout, "wait-timeout", 0,
+		"give up waiting after DURATION (0: wait forever)")
+}
+
+// Exit codes of the job commands.
+const (
+	exitJobFailed  = 2
+	exitJobRunning = 3
+)
+
+// exitError is returned by commands that exit with a specific exit
+// code.  If err is nil, no error message is printed.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+// errWaitTimeout is returned if a job is still running after the wait
+// timeout.
+var errWaitTimeout = errors.New("wait timeout")
+
+// errCancelNotSupported is returned if the server does not support to
+// cancel jobs.
+var errCancelNotSupported = errors.New("cancel not supported")
+
+// minJobPoll is the initial interval between two status checks of a
+// job.
+const minJobPoll = 500 * time.Millisecond
+
+var jobCommand = cobra.Command{
+	Use:   "job",
+	Short: "Manage jobs",
+	Long: `
+Manage the jobs of books.  The ID of a job is the ID of its book.  All
+job commands exit with 0 if the jobs are done, with 2 if any job
+failed and with 3 if any job is still running or waiting for it timed
+out (see --wait-timeout of job wait).`,
+}
+
+var jobStatusCommand = cobra.Command{
+	Use:   "status ID...",
+	Short: "Print the status of jobs",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runJobStatus,
+}
+
+func runJobStatus(_ *cobra.Command, args []string) error {
+	c := authenticate()
+	var states []*api.JobStatus
+	for _, id := range args {
+		err := eachID(c, id, bookLevels[:1], func(ids []int) error {
+			status, err := getJobStatus(c, ids[0])
+			if err != nil {
+				return err
+			}
+			format(status)
+			states = append(states, status)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("job status %s: %v", id, err)
+		}
+	}
+	return jobExitError(states...)
+}
+
+var jobWaitCommand = cobra.Command{
+	Use:   "wait ID...",
+	Short: "Wait for jobs to finish",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runJobWait,
+}
+
+func runJobWait(_ *cobra.Command, args []string) error {
+	c := authenticate()
+	var states []*api.JobStatus
+	for _, id := range args {
+		err := eachID(c, id, bookLevels[:1], func(ids []int) error {
+			status, err := waitForJob(c, ids[0], jobSleep(), jobArgs.timeout)
+			if errors.Is(err, errWaitTimeout) {
+				return exitError{code: exitJobRunning, err: err}
+			}
+			if err != nil {
+				return err
+			}
+			format(status)
+			states = append(states, status)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("job wait %s: %w", id, err)
+		}
+	}
+	return jobExitError(states...)
+}
+
+var jobWatchCommand = cobra.Command{
+	Use:   "watch ID...",
+	Short: "Watch the status of jobs",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runJobWatch,
+	Long: `
+Watch the status of the jobs of the given books.  The status of a job
+is printed whenever it changes.  The command returns if no job is
+running anymore.`,
+}
+
+func runJobWatch(_ *cobra.Command, args []string) error {
+	c := authenticate()
+	var jobIDs []int
+	for _, id := range args {
+		err := eachID(c, id, bookLevels[:1], func(ids []int) error {
+			jobIDs = append(jobIDs, ids[0])
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("job watch %s: %v", id, err)
+		}
+	}
+	last := make(map[int]api.JobStatus)
+	for {
+		var states []*api.JobStatus
+		running := false
+		for _, jobID := range jobIDs {
+			status, err := getJobStatus(c, jobID)
+			if err != nil {
+				return fmt.Errorf("job watch %d: %v", jobID, err)
+			}
+			if old, ok := last[jobID]; !ok || old.StatusID != status.StatusID {
+				format(status)
+				last[jobID] = *status
+			}
+			running = running || jobRunning(status)
+			states = append(states, status)
+		}
+		if !running {
+			return jobExitError(states...)
+		}
+		time.Sleep(jobSleep())
+	}
+}
+
+var jobCancelCommand = cobra.Command{
+	Use:   "cancel ID...",
+	Short: "Cancel jobs",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runJobCancel,
+	Long: `
+Cancel the jobs of the given books.  Cancel sends DELETE jobs/ID,
+which is not part of the documented pocoweb API.  If the server does
+not support it, cancel fails with "cancel not supported".`,
+}
+
+func runJobCancel(_ *cobra.Command, args []string) error {
+	c := authenticate()
+	for _, id := range args {
+		err := eachID(c, id, bookLevels[:1], func(ids []int) error {
+			status, err := cancelJob(c, ids[0])
+			if err != nil {
+				return err
+			}
+			format(status)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("job cancel %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// cancelJob cancels the job and returns its new status.  Responses
+// with 404, 405 or 501 are reported as errCancelNotSupported.
+func cancelJob(c *api.Client, jobID int) (*api.JobStatus, error) {
+	err := delete(c, c.URL("jobs/%d", jobID), nil)
+	if isNotSupported(err) {
+		return nil, fmt.Errorf("%w: %v", errCancelNotSupported, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return getJobStatus(c, jobID)
+}
+
+func getJobStatus(c *api.Client, jobID int) (*api.JobStatus, error) {
+	var status api.JobStatus
+	if err := get(c, c.URL("jobs/%d", jobID), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// waitForJob polls the status of the job until it is not running
+// anymore and reports its progress on stderr.  The interval between
+// two status checks starts at minJobPoll and doubles up to maxSleep.
+// It is reset whenever the status of the job changes.  If timeout is
+// positive and the job is still running after timeout, an error
+// wrapping errWaitTimeout is returned.
+func waitForJob(c *api.Client, jobID int, maxSleep, timeout time.Duration) (*api.JobStatus, error) {
+	progress := newJobProgress(jobID)
+	defer progress.Done()
+	sleep := minJobPoll
+	for {
+		status, err := getJobStatus(c, jobID)
+		if err != nil {
+			return nil, err
+		}
+		if progress.update(status) {
+			sleep = minJobPoll
+		}
+		if !jobRunning(status) {
+			return status, nil
+		}
+		if timeout > 0 {
+			left := timeout - time.Since(progress.started)
+			if left <= 0 {
+				return status, fmt.Errorf("job %d still running after %s: %w",
+					jobID, timeout, errWaitTimeout)
+			}
+			if sleep > left {
+				sleep = left
+			}
+		}
+		progress.sleep(sleep)
+		sleep = nextJobPoll(sleep, maxSleep)
+	}
+}
+
+// nextJobPoll returns the interval between two status checks that
+// follows the interval sleep.  The interval doubles up to maxSleep but
+// is never less than minJobPoll.
+func nextJobPoll(sleep, maxSleep time.Duration) time.Duration {
+	if sleep *= 2; sleep > maxSleep {
+		sleep = maxSleep
+	}
+	if sleep < minJobPoll {
+		sleep = minJobPoll
+	}
+	return sleep
+}
+
+func jobRunning(status *api.JobStatus) bool {
+	return status.StatusID != db.StatusIDDone && status.StatusID != db.StatusIDFailed
+}
+
+// jobExitError returns the exit error for the given job states.
+func jobExitError(states ...*api.JobStatus) error {
+	code := 0
+	for _, status := range states {
+		switch {
+		case status.StatusID == db.StatusIDFailed:
+			code = exitJobFailed
+		case jobRunning(status) && code == 0:
+			code = exitJobRunning
+		}
+	}
+	if code == 0 {
+		return nil
+	}
+	return exitError{code: code}
+}
+
+func jobSleep() time.Duration {
+	return time.Duration(jobArgs.sleep) * time.Second
+}
+
+func isNotSupported(err error) bool {
+	var e api.ErrorResponse
+	if errors.As(err, &e) {
+		switch e.StatusCode {
+		case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+			return true
+		}
+	}
+	return false
+}
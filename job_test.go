@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/finkf/pcwgo/api"
+	"github.com/finkf/pcwgo/db"
+)
+
+func TestNextJobPoll(t *testing.T) {
+	for _, tc := range []struct {
+		sleep, max, want time.Duration
+	}{
+		{minJobPoll, 5 * time.Second, 2 * minJobPoll},
+		{time.Second, 5 * time.Second, 2 * time.Second},
+		{2 * time.Second, 5 * time.Second, 4 * time.Second},
+		{4 * time.Second, 5 * time.Second, 5 * time.Second},
+		{5 * time.Second, 5 * time.Second, 5 * time.Second},
+		{100 * time.Millisecond, 5 * time.Second, minJobPoll},
+		{400 * time.Millisecond, 5 * time.Second, 800 * time.Millisecond},
+		{time.Second, 0, minJobPoll},
+		{time.Second, 100 * time.Millisecond, minJobPoll},
+	} {
+		if got := nextJobPoll(tc.sleep, tc.max); got != tc.want {
+			t.Errorf("nextJobPoll(%s, %s): expected %s; got %s",
+				tc.sleep, tc.max, tc.want, got)
+		}
+	}
+}
+
+func TestJobExitError(t *testing.T) {
+	status := func(id int) *api.JobStatus {
+		return &api.JobStatus{StatusID: id}
+	}
+	for _, tc := range []struct {
+		name   string
+		states []*api.JobStatus
+		want   int
+	}{
+		{"none", nil, 0},
+		{"done", []*api.JobStatus{status(db.StatusIDDone)}, 0},
+		{"failed", []*api.JobStatus{status(db.StatusIDFailed)}, exitJobFailed},
+		{"running", []*api.JobStatus{status(db.StatusIDRunning)}, exitJobRunning},
+		{"done running", []*api.JobStatus{status(db.StatusIDDone),
+			status(db.StatusIDRunning)}, exitJobRunning},
+		{"running failed", []*api.JobStatus{status(db.StatusIDRunning),
+			status(db.StatusIDFailed)}, exitJobFailed},
+		{"failed running", []*api.JobStatus{status(db.StatusIDFailed),
+			status(db.StatusIDRunning)}, exitJobFailed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := jobExitError(tc.states...)
+			if tc.want == 0 {
+				if err != nil {
+					t.Fatalf("got error: %v", err)
+				}
+				return
+			}
+			var e exitError
+			if !errors.As(err, &e) || e.code != tc.want {
+				t.Fatalf("expected exit code %d; got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+// newJobTestServer returns a server that reports the given states of
+// job 1 for subsequent status requests.  The last state is repeated.
+func newJobTestServer(t *testing.T, states ...int) (*httptest.Server, *int) {
+	var polls int
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/jobs/1" {
+			http.NotFound(w, r)
+			return
+		}
+		id := states[len(states)-1]
+		if polls < len(states) {
+			id = states[polls]
+		}
+		polls++
+		w.Header().Set("Content-Type", "application/json")
+		status := api.JobStatus{JobID: 1, BookID: 1, StatusID: id, JobName: "test"}
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			t.Errorf("encode %s: %v", r.URL, err)
+		}
+	})), &polls
+}
+
+func TestWaitForJob(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		states  []int
+		timeout time.Duration
+		want    int
+		polls   int
+		err     bool
+	}{
+		{"done", []int{db.StatusIDDone}, 0, db.StatusIDDone, 1, false},
+		{"failed", []int{db.StatusIDRunning, db.StatusIDFailed}, 0, db.StatusIDFailed, 2, false},
+		{"running done", []int{db.StatusIDRunning, db.StatusIDRunning, db.StatusIDDone},
+			0, db.StatusIDDone, 3, false},
+		{"timeout", []int{db.StatusIDRunning}, 50 * time.Millisecond, db.StatusIDRunning, 2, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, polls := newJobTestServer(t, tc.states...)
+			defer srv.Close()
+			c := api.Authenticate(srv.URL, "", false)
+			status, err := waitForJob(c, 1, minJobPoll, tc.timeout)
+			if tc.err != errors.Is(err, errWaitTimeout) {
+				t.Fatalf("expected wait timeout %t; got %v", tc.err, err)
+			}
+			if !tc.err && err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			if status == nil || status.StatusID != tc.want {
+				t.Fatalf("expected status %d; got %+v", tc.want, status)
+			}
+			if *polls != tc.polls {
+				t.Fatalf("expected %d polls; got %d", tc.polls, *polls)
+			}
+		})
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		code int
+		err  bool
+	}{
+		{"ok", http.StatusOK, false},
+		{"not found", http.StatusNotFound, true},
+		{"method not allowed", http.StatusMethodNotAllowed, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				var data interface{} = api.JobStatus{JobID: 1, StatusID: db.StatusIDFailed}
+				if r.Method == http.MethodDelete {
+					w.WriteHeader(tc.code)
+					data = api.ErrorResponse{StatusCode: tc.code, Status: http.StatusText(tc.code)}
+				}
+				if err := json.NewEncoder(w).Encode(data); err != nil {
+					t.Errorf("encode %s: %v", r.URL, err)
+				}
+			}))
+			defer srv.Close()
+			c := api.Authenticate(srv.URL, "", false)
+			status, err := cancelJob(c, 1)
+			if tc.err != errors.Is(err, errCancelNotSupported) {
+				t.Fatalf("expected cancel not supported %t; got %v", tc.err, err)
+			}
+			if !tc.err && (err != nil || status == nil || status.StatusID != db.StatusIDFailed) {
+				t.Fatalf("expected status %d; got %+v (%v)", db.StatusIDFailed, status, err)
+			}
+		})
+	}
+}
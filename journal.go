@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var undoArgs = struct {
+	journal string
+	since   string
+	last    int
+	force   bool
+}{}
+
+func init() {
+	undoCommand.Flags().IntVarP(&undoArgs.last, "last", "l", 1,
+		"undo the last N corrections")
+	undoCommand.Flags().StringVarP(&undoArgs.since, "since", "s", "",
+		"undo all corrections since TIME (RFC3339 or duration, e.g. 2h)")
+	undoCommand.Flags().StringVarP(&undoArgs.journal, "journal", "j", "",
+		"read corrections from the given journal file")
+	undoCommand.Flags().BoolVarP(&undoArgs.force, "force", "f", false,
+		"undo corrections that have been changed in the meantime")
+}
+
+// journalEntry records a single correction.  Before and After hold
+// the *api.Line or *api.Token before and after the correction.
+type journalEntry struct {
+	Time   int64       `json:"time"` // unix time in nanoseconds
+	Host   string      `json:"host"`
+	ID     string      `json:"id"`
+	Type   string      `json:"type"`
+	Undoes int64       `json:"undoes,omitempty"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// correctionState holds the fields common to lines and tokens that
+// are needed to check and undo corrections.
+type correctionState struct {
+	Cor                      string `json:"cor"`
+	OCR                      string `json:"ocr"`
+	IsManuallyCorrected      bool   `json:"isManuallyCorrected"`
+	IsAutomaticallyCorrected bool   `json:"isAutomaticallyCorrected"`
+}
+
+func journalPath() (string, error) {
+	if path := os.Getenv("POCOWEB_JOURNAL"); path != "" {
+		return path, nil
+	}
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "journal.jsonl"), nil
+}
+
+// write appends the entry to the given journal file.  If path is
+// empty, the entry is appended to the default journal.
+func (e *journalEntry) write(path string) error {
+	if path == "" {
+		var err error
+		if path, err = journalPath(); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	e.Time = time.Now().UnixNano()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(append(data, '\n')); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// journalError is returned if a correction was applied but could not
+// be recorded in the journal.
+type journalError struct {
+	id  string
+	err error
+}
+
+func (e journalError) Error() string {
+	return fmt.Sprintf("corrected %s, but cannot write journal: %v", e.id, e.err)
+}
+
+func readJournal(path string) ([]journalEntry, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	var entries []journalEntry
+	s := bufio.NewScanner(in)
+	s.Buffer(nil, 16*1024*1024)
+	for lineno := 1; s.Scan(); lineno++ {
+		var e journalEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineno, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+var undoCommand = cobra.Command{
+	Use:   "undo",
+	Short: "Undo corrections",
+	Args:  cobra.NoArgs,
+	RunE:  runUndo,
+	Long: `
+Undo corrections.  All corrections are recorded in the journal file
+$XDG_CONFIG_HOME/pcwclient/journal.jsonl (or in the file given by the
+POCOWEB_JOURNAL environment variable).  Undo restores the text of the
+lines or tokens before the correction.  Previously manually corrected
+text is restored as manual correction, previously automatically
+corrected text as automatic correction.  Uncorrected lines or tokens
+are reset.
+
+Corrections that have been changed since they were recorded are
+skipped unless --force is given.  The undo corrections are recorded in
+the journal that is undone, so corrections are never undone twice.`,
+}
+
+func runUndo(_ *cobra.Command, args []string) error {
+	path := undoArgs.journal
+	if path == "" {
+		var err error
+		if path, err = journalPath(); err != nil {
+			return fmt.Errorf("undo: %v", err)
+		}
+	}
+	entries, err := readJournal(path)
+	if err != nil {
+		return fmt.Errorf("undo: %v", err)
+	}
+	c := authenticate()
+	todo, err := selectUndoEntries(entries, c.Host)
+	if err != nil {
+		return fmt.Errorf("undo: %v", err)
+	}
+	for _, e := range todo {
+		var before, after correctionState
+		if err := convert(e.Before, &before); err != nil {
+			return fmt.Errorf("undo %s: %v", e.ID, err)
+		}
+		if err := convert(e.After, &after); err != nil {
+			return fmt.Errorf("undo %s: %v", e.ID, err)
+		}
+		typ, cor := undoCorrection(before)
+		resp, err := applyCorrectionWith(c, e.ID, typ, cor, correctionOptions{
+			undoes:  e.Time,
+			journal: path,
+			check: func(now correctionState) error {
+				if now.Cor != after.Cor && !undoArgs.force {
+					return skipError("changed since correction: " + s(now.Cor))
+				}
+				return nil
+			},
+		})
+		if _, ok := err.(skipError); ok {
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", e.ID, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("undo %s: %v", e.ID, err)
+		}
+		format(resp)
+	}
+	return nil
+}
+
+// selectUndoEntries selects the journal entries to undo in reverse
+// order.  Undo entries and already undone entries are never selected.
+func selectUndoEntries(entries []journalEntry, host string) ([]journalEntry, error) {
+	var since int64
+	if undoArgs.since != "" {
+		t, err := parseSince(undoArgs.since)
+		if err != nil {
+			return nil, err
+		}
+		since = t.UnixNano()
+	}
+	undone := make(map[int64]bool)
+	for _, e := range entries {
+		if e.Undoes != 0 {
+			undone[e.Undoes] = true
+		}
+	}
+	var todo []journalEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if since == 0 && len(todo) >= undoArgs.last {
+			break
+		}
+		if since != 0 && e.Time < since {
+			break
+		}
+		if e.Host != host || e.Undoes != 0 || undone[e.Time] {
+			continue
+		}
+		todo = append(todo, e)
+	}
+	return todo, nil
+}
+
+func parseSince(since string) (time.Time, error) {
+	if d, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return t, fmt.Errorf("invalid time: %q", since)
+	}
+	return t, nil
+}
+
+func undoCorrection(before correctionState) (string, string) {
+	switch {
+	case before.IsManuallyCorrected:
+		return "manual", before.Cor
+	case before.IsAutomaticallyCorrected:
+		return "automatic", before.Cor
+	default:
+		return "reset", before.Cor
+	}
+}
+
+// convert converts between json compatible types.
+func convert(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/finkf/pcwgo/api"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcwclient-journal")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sub", "journal.jsonl")
+	entries := []journalEntry{
+		{
+			Host:   "http://localhost",
+			ID:     "1:2:3:4",
+			Type:   "manual",
+			Before: &api.Token{OCR: "daſ", Cor: "daſ"},
+			After:  &api.Token{OCR: "daſ", Cor: "das", IsManuallyCorrected: true},
+		},
+		{
+			Host:   "http://localhost",
+			ID:     "1:2:3",
+			Type:   "automatic",
+			Before: &api.Line{OCR: "a b", Cor: "a c", IsAutomaticallyCorrected: true},
+			After:  &api.Line{OCR: "a b", Cor: "a d", IsAutomaticallyCorrected: true},
+		},
+	}
+	for i := range entries {
+		if err := entries[i].write(path); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+	}
+	entries[1].Undoes = entries[0].Time
+	if err := entries[1].write(path); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	got, err := readJournal(path)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 entries; got %d", len(got))
+	}
+	for i, want := range []struct {
+		id            string
+		undoes        int64
+		before, after correctionState
+	}{
+		{"1:2:3:4", 0, correctionState{Cor: "daſ", OCR: "daſ"},
+			correctionState{Cor: "das", OCR: "daſ", IsManuallyCorrected: true}},
+		{"1:2:3", 0, correctionState{Cor: "a c", OCR: "a b", IsAutomaticallyCorrected: true},
+			correctionState{Cor: "a d", OCR: "a b", IsAutomaticallyCorrected: true}},
+		{"1:2:3", got[0].Time, correctionState{Cor: "a c", OCR: "a b", IsAutomaticallyCorrected: true},
+			correctionState{Cor: "a d", OCR: "a b", IsAutomaticallyCorrected: true}},
+	} {
+		e := got[i]
+		if e.ID != want.id || e.Host != "http://localhost" || e.Undoes != want.undoes || e.Time == 0 {
+			t.Errorf("entry %d: unexpected entry %+v", i, e)
+		}
+		var before, after correctionState
+		if err := convert(e.Before, &before); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		if err := convert(e.After, &after); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		if before != want.before || after != want.after {
+			t.Errorf("entry %d: expected %+v -> %+v; got %+v -> %+v",
+				i, want.before, want.after, before, after)
+		}
+	}
+}
+
+func TestJournalDefaultPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcwclient-journal")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.jsonl")
+	old, ok := os.LookupEnv("POCOWEB_JOURNAL")
+	os.Setenv("POCOWEB_JOURNAL", path)
+	defer func() {
+		if ok {
+			os.Setenv("POCOWEB_JOURNAL", old)
+		} else {
+			os.Unsetenv("POCOWEB_JOURNAL")
+		}
+	}()
+	e := journalEntry{Host: "h", ID: "1:1:1"}
+	if err := e.write(""); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	got, err := readJournal(path)
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != "1:1:1" {
+		t.Fatalf("unexpected entries: %+v", got)
+	}
+}
+
+func TestReadJournalInvalid(t *testing.T) {
+	file, err := ioutil.TempFile("", "pcwclient-journal")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("{\"id\":\"1\"}\nnot json\n")
+	file.Close()
+	if _, err := readJournal(file.Name()); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestSelectUndoEntries(t *testing.T) {
+	defer func(last int, since string) {
+		undoArgs.last, undoArgs.since = last, since
+	}(undoArgs.last, undoArgs.since)
+	now := time.Now()
+	at := func(d time.Duration) int64 {
+		return now.Add(-d).UnixNano()
+	}
+	entries := []journalEntry{
+		{Time: at(3 * time.Hour), Host: "a", ID: "1"},
+		{Time: at(2 * time.Hour), Host: "a", ID: "2"},
+		{Time: at(90 * time.Minute), Host: "b", ID: "3"},
+		{Time: at(time.Hour), Host: "a", ID: "4"},
+		{Time: at(30 * time.Minute), Host: "a", ID: "5"},
+		{Time: at(10 * time.Minute), Host: "a", ID: "5", Undoes: at(30 * time.Minute)},
+	}
+	for _, tc := range []struct {
+		last  int
+		since string
+		host  string
+		want  []string
+	}{
+		{1, "", "a", []string{"4"}},
+		{2, "", "a", []string{"4", "2"}},
+		{10, "", "a", []string{"4", "2", "1"}},
+		{1, "", "b", []string{"3"}},
+		{1, "", "c", nil},
+		{1, "150m", "a", []string{"4", "2"}},
+		{1, "150m", "b", []string{"3"}},
+		{1, "1m", "a", nil},
+	} {
+		undoArgs.last, undoArgs.since = tc.last, tc.since
+		got, err := selectUndoEntries(entries, tc.host)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		var ids []string
+		for _, e := range got {
+			ids = append(ids, e.ID)
+		}
+		if !reflect.DeepEqual(ids, tc.want) {
+			t.Errorf("last=%d since=%q host=%s: expected %v; got %v",
+				tc.last, tc.since, tc.host, tc.want, ids)
+		}
+	}
+	undoArgs.since = "yesterday"
+	if _, err := selectUndoEntries(entries, "a"); err == nil {
+		t.Errorf("expected error for invalid since")
+	}
+}
+
+func TestUndoCorrection(t *testing.T) {
+	for _, tc := range []struct {
+		before   correctionState
+		typ, cor string
+	}{
+		{correctionState{Cor: "a", OCR: "a"}, "reset", "a"},
+		{correctionState{Cor: "b", OCR: "a", IsManuallyCorrected: true}, "manual", "b"},
+		{correctionState{Cor: "b", OCR: "a", IsAutomaticallyCorrected: true}, "automatic", "b"},
+	} {
+		typ, cor := undoCorrection(tc.before)
+		if typ != tc.typ || cor != tc.cor {
+			t.Errorf("%+v: expected %s %q; got %s %q", tc.before, tc.typ, tc.cor, typ, cor)
+		}
+	}
+}
@@ -37,7 +37,7 @@ given, information about all users is listed.`,
 }
 
 func doListUsers(cmd *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	if len(args) == 0 {
 		return listAllUsers(c)
 	}
@@ -46,15 +46,17 @@ func doListUsers(cmd *cobra.Command, args []string) error {
 
 func listUsers(c *api.Client, ids ...string) error {
 	for _, id := range ids {
-		var uid int
-		if n := parseIDs(id, &uid); n != 1 {
-			return fmt.Errorf("list user: invalid user id: %q", id)
-		}
-		var user api.User
-		if err := get(c, c.URL("users/%d", uid), &user); err != nil {
-			return fmt.Errorf("list user %d: %v", uid, err)
+		err := eachID(c, id, userLevels, func(ids []int) error {
+			var user api.User
+			if err := get(c, c.URL("users/%d", ids[0]), &user); err != nil {
+				return err
+			}
+			format(&user)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("list user %s: %v", id, err)
 		}
-		format(&user)
 	}
 	return nil
 }
@@ -79,7 +81,7 @@ and/or packages is listed.`,
 }
 
 func doListBooks(cmd *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	if len(args) == 0 {
 		return listAllBooks(c)
 	}
@@ -88,28 +90,39 @@ func doListBooks(cmd *cobra.Command, args []string) error {
 
 func listBooks(c *api.Client, ids ...string) error {
 	for _, id := range ids {
-		var bid int
-		if n := parseIDs(id, &bid); n != 1 {
-			return fmt.Errorf("list book: invalid book id: %q", id)
-		}
-		var book api.Book
-		if err := get(c, c.URL("books/%d", bid), &book); err != nil {
-			return fmt.Errorf("list book %d: %v", bid, err)
+		err := eachID(c, id, bookLevels[:1], func(ids []int) error {
+			var book api.Book
+			if err := get(c, c.URL("books/%d", ids[0]), &book); err != nil {
+				return err
+			}
+			format(&book)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("list book %s: %v", id, err)
 		}
-		format(&book)
 	}
 	return nil
 }
 
 func listAllBooks(c *api.Client) error {
-	var books api.Books
-	if err := get(c, c.URL("books"), &books); err != nil {
+	books, err := getAllBooks(c)
+	if err != nil {
 		return fmt.Errorf("list books: %v", err)
 	}
-	format(&books)
+	format(books)
 	return nil
 }
 
+// getAllBooks returns all books and packages of the logged in user.
+func getAllBooks(c *api.Client) (*api.Books, error) {
+	var books api.Books
+	if err := get(c, c.URL("books"), &books); err != nil {
+		return nil, err
+	}
+	return &books, nil
+}
+
 var listPatternsCommand = cobra.Command{
 	Use:   "patterns ID [QUERY...]",
 	Short: "list patterns for the given book",
@@ -118,18 +131,15 @@ var listPatternsCommand = cobra.Command{
 }
 
 func doListPatterns(_ *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
-	var bid int
-	if n := parseIDs(args[0], &bid); n != 1 {
-		return fmt.Errorf("list patterns: invalid book id: %q", args[0])
-	}
+	c := authenticate()
 	u := unescape(args...)
-	switch len(u) {
-	case 1:
-		return listPatterns(c, bid)
-	default:
-		return listPatterns(c, bid, u[1:]...)
+	err := eachID(c, args[0], bookLevels[:1], func(ids []int) error {
+		return listPatterns(c, ids[0], u[1:]...)
+	})
+	if err != nil {
+		return fmt.Errorf("list patterns for book %s: %v", args[0], err)
 	}
+	return nil
 }
 
 func listPatterns(c *api.Client, id int, qs ...string) error {
@@ -139,7 +149,7 @@ func listPatterns(c *api.Client, id int, qs ...string) error {
 	}
 	var counts api.PatternCounts
 	if err := get(c, uri, &counts); err != nil {
-		return fmt.Errorf("list patterns for book %d: %v", id, err)
+		return err
 	}
 	format(&counts)
 	return nil
@@ -153,18 +163,15 @@ var listSuggestionsCommand = cobra.Command{
 }
 
 func doListSuggestions(cmd *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
-	var bid int
-	if n := parseIDs(args[0], &bid); n != 1 {
-		return fmt.Errorf("list suggestions: invalid book id: %q", args[0])
-	}
+	c := authenticate()
 	u := unescape(args...)
-	switch len(u) {
-	case 1:
-		return listSuggestions(c, bid)
-	default:
-		return listSuggestions(c, bid, u[1:]...)
+	err := eachID(c, args[0], bookLevels[:1], func(ids []int) error {
+		return listSuggestions(c, ids[0], u[1:]...)
+	})
+	if err != nil {
+		return fmt.Errorf("list suggestions for book %s: %v", args[0], err)
 	}
+	return nil
 }
 
 func listSuggestions(c *api.Client, id int, qs ...string) error {
@@ -177,14 +184,14 @@ func listSuggestions(c *api.Client, id int, qs ...string) error {
 	if len(qs) == 0 {
 		var profile gofiler.Profile
 		if err := get(c, uri, &profile); err != nil {
-			return fmt.Errorf("list suggestions for book %d: %v", id, err)
+			return err
 		}
 		format(profile)
 		return nil
 	}
 	var suggs api.Suggestions
 	if err := get(c, uri, &suggs); err != nil {
-		return fmt.Errorf("list suggestions for book %d: %v", id, err)
+		return err
 	}
 	format(suggs)
 	return nil
@@ -199,18 +206,21 @@ var listSuspiciousCommand = cobra.Command{
 }
 
 func doListSuspicious(_ *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	for i := range args {
-		var bid int
-		if n := parseIDs(args[i], &bid); n != 1 {
-			return fmt.Errorf("list suspicious: invalid book id: %q", args[i])
-		}
-		url := c.URL("profile/suspicious/books/%d", bid)
-		var counts api.SuggestionCounts
-		if err := get(c, url, &counts); err != nil {
-			return fmt.Errorf("list suspicious for %d: %v", bid, err)
+		err := eachID(c, args[i], bookLevels[:1], func(ids []int) error {
+			bid := ids[0]
+			url := c.URL("profile/suspicious/books/%d", bid)
+			var counts api.SuggestionCounts
+			if err := get(c, url, &counts); err != nil {
+				return err
+			}
+			format(&counts)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("list suspicious for %s: %v", args[i], err)
 		}
-		format(&counts)
 	}
 	return nil
 }
@@ -223,18 +233,21 @@ var listAdaptiveCommand = cobra.Command{
 }
 
 func doListAdaptive(_ *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	for i := range args {
-		var bid int
-		if n := parseIDs(args[i], &bid); n != 1 {
-			return fmt.Errorf("list adaptive tokens: invalid book id: %q", args[i])
-		}
-		url := c.URL("profile/adaptive/books/%d", bid)
-		var tokens api.AdaptiveTokens
-		if err := get(c, url, &tokens); err != nil {
-			return fmt.Errorf("list adaptive tokens for book %d: %v", bid, err)
+		err := eachID(c, args[i], bookLevels[:1], func(ids []int) error {
+			bid := ids[0]
+			url := c.URL("profile/adaptive/books/%d", bid)
+			var tokens api.AdaptiveTokens
+			if err := get(c, url, &tokens); err != nil {
+				return err
+			}
+			format(&tokens)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("list adaptive tokens for book %s: %v", args[i], err)
 		}
-		format(&tokens)
 	}
 	return nil
 }
@@ -246,18 +259,21 @@ var listELCommand = cobra.Command{
 }
 
 func doListEL(_ *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	for i := range args {
-		var bid int
-		if n := parseIDs(args[i], &bid); n != 1 {
-			return fmt.Errorf("list extended lexicon entries: invalid book id: %q", args[i])
-		}
-		url := c.URL("postcorrect/le/books/%d", bid)
-		var el api.ExtendedLexicon
-		if err := get(c, url, &el); err != nil {
-			return fmt.Errorf("list extended lexicon entries for book %d: %v", bid, err)
+		err := eachID(c, args[i], bookLevels[:1], func(ids []int) error {
+			bid := ids[0]
+			url := c.URL("postcorrect/le/books/%d", bid)
+			var el api.ExtendedLexicon
+			if err := get(c, url, &el); err != nil {
+				return err
+			}
+			format(&el)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("list extended lexicon entries for book %s: %v", args[i], err)
 		}
-		format(&el)
 	}
 	return nil
 }
@@ -269,18 +285,21 @@ var listRRDMCommand = cobra.Command{
 }
 
 func doListRRDM(_ *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	for i := range args {
-		var bid int
-		if n := parseIDs(args[i], &bid); n != 1 {
-			return fmt.Errorf("list post corrections: invalid book id: %q", args[i])
-		}
-		url := c.URL("postcorrect/books/%d", bid)
-		var pc api.PostCorrection
-		if err := get(c, url, &pc); err != nil {
-			return fmt.Errorf("list post corrections for book %d: %v", bid, err)
+		err := eachID(c, args[i], bookLevels[:1], func(ids []int) error {
+			bid := ids[0]
+			url := c.URL("postcorrect/books/%d", bid)
+			var pc api.PostCorrection
+			if err := get(c, url, &pc); err != nil {
+				return err
+			}
+			format(&pc)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("list post corrections for book %s: %v", args[i], err)
 		}
-		format(&pc)
 	}
 	return nil
 }
@@ -293,19 +312,22 @@ var listCharsCommand = cobra.Command{
 }
 
 func doListChars(_ *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	for i := range args {
-		var bid int
-		if n := parseIDs(args[i], &bid); n != 1 {
-			return fmt.Errorf("list chars: invalid book id: %q", args[i])
-		}
-		url := c.URL("books/%d/charmap?filter=%s",
-			bid, url.QueryEscape(charFilter()))
-		var chars api.CharMap
-		if err := get(c, url, &chars); err != nil {
-			return fmt.Errorf("list chars for book %d: %v", bid, err)
+		err := eachID(c, args[i], bookLevels[:1], func(ids []int) error {
+			bid := ids[0]
+			url := c.URL("books/%d/charmap?filter=%s",
+				bid, url.QueryEscape(charFilter()))
+			var chars api.CharMap
+			if err := get(c, url, &chars); err != nil {
+				return err
+			}
+			format(&chars)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("list chars for book %s: %v", args[i], err)
 		}
-		format(&chars)
 	}
 	return nil
 }
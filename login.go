@@ -1,45 +1,77 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/UNO-SOFT/ulog"
 	"github.com/finkf/pcwgo/api"
 	"github.com/spf13/cobra"
 )
 
+func init() {
+	addPasswordFlags(loginCommand.Flags())
+}
+
 var loginCommand = cobra.Command{
-	Use:   "login [EMAIL PASSWORD]",
+	Use:   "login [EMAIL]",
 	Short: "login to pocoweb or get logged in user",
 	RunE:  runLogin,
-	Args:  exactArgs(0, 2),
+	Args:  exactArgs(0, 1, 2),
+	Long: `
+Login to pocoweb.  The password is read from an interactive prompt,
+from stdin (--password-stdin) or from a file (--password-file).  The
+session is stored in a credential file for the active profile and
+pocoweb url and is used automatically by subsequent commands for the
+same url.  If no EMAIL is given, the logged in user is printed.
+
+For backward compatibility the password can still be given as second
+argument.  This is discouraged, since it ends up in the shell's
+history and in the process list.`,
 }
 
 func runLogin(cmd *cobra.Command, args []string) error {
-	if len(args) == 2 {
-		user, password := args[0], args[1]
-		return login(user, password)
+	switch len(args) {
+	case 2:
+		return login(args[0], args[1])
+	case 1:
+		password, err := readPassword("Password")
+		if err != nil {
+			return fmt.Errorf("login: %v", err)
+		}
+		return login(args[0], password)
 	}
 	return getLogin()
 }
 
 func login(user, password string) error {
-	// if mainArgs.debug {
-	// 	log.SetLevel(log.DebugLevel)
-	// }
 	url := getURL()
 	if url == "" {
-		return fmt.Errorf("login: missing url: use --url or POCOWEB_URL")
+		return fmt.Errorf("login: missing url: use --url, --profile or POCOWEB_URL")
 	}
-	c, err := api.Login(url, user, password, mainArgs.skipVerify)
+	c, err := api.Login(url, user, password, getSkipVerify())
 	if err != nil {
 		return fmt.Errorf("login: %v", err)
 	}
-	format(c.Session)
+	if err := writeSession(url, c.Session); err != nil {
+		return fmt.Errorf("login: store session: %v", err)
+	}
 	return nil
 }
 
 func getLogin() error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	if s, err := readSession(getURL()); err == nil && s.Expired() {
+		return fmt.Errorf("get login: session of %s expired at %s",
+			s.User.Email, time.Unix(s.Expires, 0).Format(time.RFC3339))
+	}
+	c := authenticate()
 	var session api.Session
 	if err := get(c, c.URL("login"), &session); err != nil {
 		return fmt.Errorf("get login: %v", err)
@@ -56,9 +88,138 @@ var logoutCommand = cobra.Command{
 }
 
 func runLogout(_ *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
-	if err := get(c, c.URL("login"), nil); err != nil {
+	c := authenticate()
+	err := get(c, c.URL("logout"), nil)
+	if e := removeSession(c.Host); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
 		return fmt.Errorf("logout: %v", err)
 	}
 	return nil
 }
+
+// reloginMutex serializes concurrent logins.
+var reloginMutex sync.Mutex
+
+// sessionMutex guards the sessions of clients that are shared between
+// goroutines.  The session is updated by relogin while other requests
+// are running.
+var sessionMutex sync.RWMutex
+
+// sessionAuth returns the auth token of the client's current session.
+func sessionAuth(c *api.Client) string {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return c.Session.Auth
+}
+
+// relogin logs in again using the email and the credential helper of
+// the active profile.  On success the client's session is updated and
+// stored in the credential file.  If the session is not the rejected
+// session auth anymore, another request has already logged in again.
+func relogin(c *api.Client, auth string) error {
+	reloginMutex.Lock()
+	defer reloginMutex.Unlock()
+	if sessionAuth(c) != auth {
+		return nil
+	}
+	p := getProfile()
+	if p.Email == "" || p.CredentialHelper == "" || !sameURL(p.URL, c.Host) {
+		return fmt.Errorf("relogin: no credentials available for %s", c.Host)
+	}
+	ulog.Write("relogin", "email", p.Email, "url", c.Host)
+	out, err := exec.Command("sh", "-c", p.CredentialHelper).Output()
+	if err != nil {
+		return fmt.Errorf("relogin: credential helper: %v", err)
+	}
+	password := strings.SplitN(string(out), "\n", 2)[0]
+	nc, err := api.Login(c.Host, p.Email, strings.TrimRight(password, "\r"),
+		getSkipVerify())
+	if err != nil {
+		return fmt.Errorf("relogin: %v", err)
+	}
+	sessionMutex.Lock()
+	c.Session = nc.Session
+	sessionMutex.Unlock()
+	return writeSession(c.Host, nc.Session)
+}
+
+// storedSession is a session stored in a credential file together
+// with the url of the pocoweb instance it belongs to.
+type storedSession struct {
+	URL string `json:"url"`
+	api.Session
+}
+
+// sessionPath returns the path of the credential file of the active
+// profile and the given pocoweb url.
+func sessionPath(url string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	name := getProfile().Name
+	if name == "" {
+		name = "default"
+	}
+	host := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.TrimRight(url, "/"))
+	return filepath.Join(dir, "sessions", name+"@"+host+".json"), nil
+}
+
+// readSession reads the stored session for the given pocoweb url.
+// Sessions stored for another url are refused.
+func readSession(url string) (*api.Session, error) {
+	path, err := sessionPath(url)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s storedSession
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("read session %s: %v", path, err)
+	}
+	if !sameURL(s.URL, url) {
+		return nil, fmt.Errorf("read session %s: session of %s", path, s.URL)
+	}
+	return &s.Session, nil
+}
+
+func writeSession(url string, s api.Session) error {
+	path, err := sessionPath(url)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(storedSession{URL: url, Session: s})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func removeSession(url string) error {
+	path, err := sessionPath(url)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func sameURL(a, b string) bool {
+	return a != "" && strings.TrimRight(a, "/") == strings.TrimRight(b, "/")
+}
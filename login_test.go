@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/finkf/pcwgo/api"
+)
+
+// newLoginTestServer returns a server that accepts the password secret
+// for the user a@b.  Logins return the auth token new.  All other
+// requests fail with 401 unless they send the auth token new.
+func newLoginTestServer(t *testing.T, logins *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply := func(code int, data interface{}) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			if err := json.NewEncoder(w).Encode(data); err != nil {
+				t.Errorf("encode %s: %v", r.URL, err)
+			}
+		}
+		unauthorized := api.ErrorResponse{StatusCode: http.StatusUnauthorized,
+			Status: "401 Unauthorized"}
+		if r.URL.Path == "/rest/login" && r.Method == http.MethodPost {
+			*logins++
+			var login api.LoginRequest
+			if err := json.NewDecoder(r.Body).Decode(&login); err != nil ||
+				login.Email != "a@b" || login.Password != "secret" {
+				reply(http.StatusUnauthorized, unauthorized)
+				return
+			}
+			reply(http.StatusOK, api.Session{User: api.User{Email: "a@b"}, Auth: "new"})
+			return
+		}
+		if r.Header.Get("Authorization") != "new" {
+			reply(http.StatusUnauthorized, unauthorized)
+			return
+		}
+		reply(http.StatusOK, api.Book{ProjectID: 1})
+	}))
+}
+
+func TestRelogin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcwclient-login")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	old, ok := os.LookupEnv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	defer func() {
+		if ok {
+			os.Setenv("XDG_CONFIG_HOME", old)
+		} else {
+			os.Unsetenv("XDG_CONFIG_HOME")
+		}
+	}()
+	defer func(p *profile, flag string) {
+		activeProfile, activeProfileFlag = p, flag
+	}(activeProfile, activeProfileFlag)
+	var logins int
+	srv := newLoginTestServer(t, &logins)
+	defer srv.Close()
+	for _, tc := range []struct {
+		name       string
+		url, email string
+		helper     string
+		logins     int
+		err        bool
+	}{
+		{"ok", srv.URL, "a@b", "echo secret", 1, false},
+		{"crlf", srv.URL, "a@b", `printf 'secret\r\nignored\n'`, 1, false},
+		{"wrong password", srv.URL, "a@b", "echo wrong", 1, true},
+		{"helper fails", srv.URL, "a@b", "exit 1", 0, true},
+		{"no helper", srv.URL, "a@b", "", 0, true},
+		{"no email", srv.URL, "", "echo secret", 0, true},
+		{"other url", "http://example.com", "a@b", "echo secret", 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			logins = 0
+			activeProfile = &profile{Name: "test", URL: tc.url, Email: tc.email,
+				CredentialHelper: tc.helper}
+			activeProfileFlag = mainArgs.profile
+			c := api.Authenticate(srv.URL, "expired", false)
+			var book api.Book
+			err := get(c, c.URL("books/1"), &book)
+			if logins != tc.logins {
+				t.Fatalf("expected %d logins; got %d", tc.logins, logins)
+			}
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				if c.Session.Auth != "expired" {
+					t.Fatalf("expected unchanged session; got %q", c.Session.Auth)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			if book.ProjectID != 1 || c.Session.Auth != "new" {
+				t.Fatalf("unexpected book %d and auth %q", book.ProjectID, c.Session.Auth)
+			}
+			s, err := readSession(srv.URL)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			if s == nil || s.Auth != "new" {
+				t.Fatalf("expected stored session new; got %+v", s)
+			}
+		})
+	}
+}
@@ -1,21 +1,30 @@
 package main // import "github.com/finkf/pcwclient"
 import (
+	"errors"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
 	"github.com/spf13/cobra"
 )
 
 // various command line flags
 var mainArgs = struct {
-	debug, skipVerify     bool
-	authToken, pocowebURL string
+	debug, skipVerify              bool
+	authToken, pocowebURL, profile string
+	timeout, retryBackoff          time.Duration
+	retries                        int
 }{}
 
 var mainCommand = &cobra.Command{
 	Use:   "pcwclient",
 	Short: "Command line client for pocoweb",
-	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 		// if mainArgs.debug {
 		// 	log.SetLevel(log.DebugLevel)
 		// }
+		return checkOutputArgs()
 	},
 	Long: `
 Command line client for pocoweb. You can use it to automate or test
@@ -24,7 +33,19 @@ the pocoweb post-correction.
 In order to use the command line client, you should use the
 POCOWEB_URL and POCOWEB_AUTH environment varibales to set the url and
 the authentification token respectively or set the appropriate --url
-and --auth parameters accordingly.`,
+and --auth parameters accordingly.
+
+Alternatively you can store named server profiles using the config
+command and select them with the --profile parameter.
+
+Use --output to print the results as aligned table, csv, tsv, json
+lines or json array with a header row and --columns to select the
+columns to print.  Interactive commands (review and replace without
+--yes or --dry-run) do not support --output.
+
+GET, PUT and DELETE requests are retried on network and server
+errors (see --retries and --retry-backoff).  Other requests are never
+retried.`,
 }
 
 func init() {
@@ -36,8 +57,24 @@ func init() {
 	mainCommand.AddCommand(&versionCommand)
 	mainCommand.AddCommand(&searchCommand)
 	mainCommand.AddCommand(&correctCommand)
+	mainCommand.AddCommand(&replaceCommand)
+	mainCommand.AddCommand(&undoCommand)
+	mainCommand.AddCommand(&applyCommand)
+	applyCommand.AddCommand(&applyRRDMCommand)
+	applyCommand.AddCommand(&applySuggestionsCommand)
+	mainCommand.AddCommand(&reviewCommand)
+	mainCommand.AddCommand(&shellCommand)
 	mainCommand.AddCommand(&downloadCommand)
 	mainCommand.AddCommand(&pkgCommand)
+	mainCommand.AddCommand(&configCommand)
+	mainCommand.AddCommand(&validateCommand)
+	mainCommand.AddCommand(&exportCommand)
+	exportCommand.AddCommand(&exportGTCommand)
+	validateCommand.AddCommand(&validateBookCommand)
+	configCommand.AddCommand(&configAddCommand)
+	configCommand.AddCommand(&configListCommand)
+	configCommand.AddCommand(&configRemoveCommand)
+	configCommand.AddCommand(&configDefaultCommand)
 	downloadCommand.AddCommand(&downloadBookCommand)
 	downloadCommand.AddCommand(&downloadPoolCommand)
 	pkgCommand.AddCommand(&pkgAssignCommand)
@@ -45,6 +82,12 @@ func init() {
 	pkgCommand.AddCommand(&pkgSplitCommand)
 	mainCommand.AddCommand(&deleteCommand)
 	mainCommand.AddCommand(&startCommand)
+	mainCommand.AddCommand(&pipelineCommand)
+	mainCommand.AddCommand(&jobCommand)
+	jobCommand.AddCommand(&jobStatusCommand)
+	jobCommand.AddCommand(&jobWaitCommand)
+	jobCommand.AddCommand(&jobWatchCommand)
+	jobCommand.AddCommand(&jobCancelCommand)
 	listCommand.AddCommand(&listBooksCommand)
 	listCommand.AddCommand(&listUsersCommand)
 	listCommand.AddCommand(&listPatternsCommand)
@@ -71,13 +114,35 @@ func init() {
 	mainCommand.PersistentFlags().BoolVarP(&mainArgs.debug, "debug", "D", false,
 		"enable debug output")
 	mainCommand.PersistentFlags().StringVarP(&mainArgs.pocowebURL, "url", "U",
-		getURL(), "set pocoweb url")
+		"", "set pocoweb url (default: $POCOWEB_URL)")
 	mainCommand.PersistentFlags().StringVarP(&formatArgs.template, "format", "F",
 		"", "set output format")
 	mainCommand.PersistentFlags().StringVarP(&mainArgs.authToken, "auth", "A",
-		getAuth(), "set auth token")
+		"", "set auth token (default: $POCOWEB_AUTH)")
+	mainCommand.PersistentFlags().StringVarP(&mainArgs.profile, "profile", "P",
+		"", "use the named server profile")
+	mainCommand.PersistentFlags().DurationVar(&mainArgs.timeout, "timeout",
+		0, "set the timeout to wait for the response of each request (0: no timeout)")
+	mainCommand.PersistentFlags().IntVar(&mainArgs.retries, "retries",
+		3, "set the number of retries for idempotent requests")
+	mainCommand.PersistentFlags().DurationVar(&mainArgs.retryBackoff,
+		"retry-backoff", time.Second, "set the initial backoff between retries")
+	mainCommand.PersistentFlags().StringVar(&outputArgs.output, "output",
+		"", "set output format (table, csv, tsv, jsonl or json)")
+	mainCommand.PersistentFlags().StringSliceVar(&outputArgs.columns, "columns",
+		nil, "set the columns to output (implies --output table)")
 }
 
 func main() {
-	chk(mainCommand.Execute())
+	rand.Seed(time.Now().UnixNano())
+	err := mainCommand.Execute()
+	flushOutput()
+	var e exitError
+	if errors.As(err, &e) {
+		if e.err != nil {
+			log.Printf("error: %v", err)
+		}
+		os.Exit(e.code)
+	}
+	chk(err)
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// TestHelp runs --help for all commands.  This catches conflicting
+// flags, since cobra panics while merging the flags of a command.
+func TestHelp(t *testing.T) {
+	var cmds []*cobra.Command
+	var walk func(*cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		cmds = append(cmds, cmd)
+		for _, sub := range cmd.Commands() {
+			walk(sub)
+		}
+	}
+	walk(mainCommand)
+	for _, cmd := range cmds {
+		path := strings.Fields(cmd.CommandPath())[1:]
+		t.Run(strings.Join(append([]string{"pcwclient"}, path...), " "), func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("panic: %v", r)
+				}
+			}()
+			var out bytes.Buffer
+			mainCommand.SetOut(&out)
+			mainCommand.SetErr(&out)
+			defer mainCommand.SetOut(nil)
+			defer mainCommand.SetErr(nil)
+			mainCommand.SetArgs(append(path, "--help"))
+			if err := mainCommand.Execute(); err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			if !strings.Contains(out.String(), cmd.CommandPath()) {
+				t.Fatalf("usage %q not found in %q", cmd.CommandPath(), out.String())
+			}
+		})
+	}
+}
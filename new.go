@@ -2,10 +2,8 @@ package main
 
 import (
 	"archive/zip"
-	"bytes"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
@@ -32,6 +30,10 @@ func init() {
 		"set book's year")
 	newBookCommand.Flags().StringVarP(&newBookArgs.histPatterns, "patters", "p", "",
 		"set additional historical patterns for the book")
+	newBookCommand.Flags().BoolVarP(&newBookArgs.noValidate, "no-validate", "V",
+		false, "do not validate the book before uploading it")
+	newBookCommand.Flags().StringVarP(&newBookArgs.keepZIP, "keep-zip", "k", "",
+		"keep a copy of the uploaded zip archive in the given file")
 	_ = cobra.MarkFlagRequired(newBookCommand.Flags(), "author")
 	_ = cobra.MarkFlagRequired(newBookCommand.Flags(), "title")
 	_ = cobra.MarkFlagRequired(newBookCommand.Flags(), "language")
@@ -40,14 +42,14 @@ func init() {
 	newUserCommand.Flags().StringVarP(&newUserArgs.email, "email", "e", "",
 		"set the user's name (required)")
 	newUserCommand.Flags().StringVarP(&newUserArgs.password, "password", "p",
-		"", "set the user's password (required)")
+		"", "set the user's password (discouraged: prompt if omitted)")
+	addPasswordFlags(newUserCommand.Flags())
 	newUserCommand.Flags().StringVarP(&newUserArgs.institute, "institute",
 		"i", "", "set the user's institute")
 	newUserCommand.Flags().BoolVarP(&newUserArgs.admin, "admin", "a", false,
 		"user has administrator permissions")
 	_ = cobra.MarkFlagRequired(newUserCommand.Flags(), "name")
 	_ = cobra.MarkFlagRequired(newUserCommand.Flags(), "email")
-	_ = cobra.MarkFlagRequired(newUserCommand.Flags(), "password")
 }
 
 var newCommand = cobra.Command{
@@ -69,23 +71,36 @@ var newBookArgs = struct {
 	language     string
 	profilerURL  string
 	histPatterns string
+	keepZIP      string
 	year         int
+	noValidate   bool
 }{}
 
 func newBook(_ *cobra.Command, args []string) error {
-	zip, err := openAsZIP(args[0])
+	if !newBookArgs.noValidate {
+		if err := validateBeforeUpload(args[0]); err != nil {
+			return fmt.Errorf("cannot create new book: %v", err)
+		}
+	}
+	zip, size, err := openAsZIP(args[0])
 	if err != nil {
 		return fmt.Errorf("cannot create new book: open %s: %v", args[0], err)
 	}
 	defer zip.Close()
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	url := newBookURL(c)
-	req, err := http.NewRequest(http.MethodPost, url, zip)
+	progress := newProgressReader(zip, "upload "+args[0], size)
+	req, cancel, err := newRequest(http.MethodPost, url, progress)
 	if err != nil {
 		return fmt.Errorf("cannot create new book: %v", err)
 	}
+	defer cancel()
+	req.ContentLength = size
 	req.Header.Add("Content-Type", "application/zip")
-	res, err := c.Do(req)
+	// The upload is not limited by the global timeout, since the
+	// zip is sent before the response headers are received.
+	res, err := doRequestTimeout(c, req, 0)
+	progress.Done()
 	if err != nil {
 		return fmt.Errorf("cannot create new book: %v", err)
 	}
@@ -97,21 +112,48 @@ func newBook(_ *cobra.Command, args []string) error {
 	return nil
 }
 
-func openAsZIP(p string) (io.ReadCloser, error) {
+// openAsZIP opens the given zip file or directory as zip archive.
+// The size of the archive is returned, or -1 if it is not known.
+// Directories are zipped on the fly while the archive is read.
+func openAsZIP(p string) (io.ReadCloser, int64, error) {
 	fi, err := os.Lstat(p)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if !fi.IsDir() {
-		return os.Open(p)
+		in, err := os.Open(p)
+		return in, fi.Size(), err
+	}
+	var keep *os.File
+	if newBookArgs.keepZIP != "" {
+		if keep, err = os.Create(newBookArgs.keepZIP); err != nil {
+			return nil, 0, err
+		}
 	}
-	var buf bytes.Buffer
-	w := zip.NewWriter(&buf)
+	r, w := io.Pipe()
+	go func() {
+		var out io.Writer = w
+		if keep != nil {
+			out = io.MultiWriter(w, keep)
+		}
+		err := writeZIP(out, p)
+		if keep != nil {
+			if e := keep.Close(); err == nil {
+				err = e
+			}
+		}
+		w.CloseWithError(err)
+	}()
+	return r, -1, nil
+}
+
+func writeZIP(out io.Writer, p string) error {
+	w := zip.NewWriter(out)
 	prefix := len(path.Dir(p))
 	if prefix > 0 { // increment prefix to include the slash if non empty prefix
 		prefix++
 	}
-	err = filepath.Walk(p, func(p string, fi os.FileInfo, err error) error {
+	err := filepath.Walk(p, func(p string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -146,11 +188,11 @@ func openAsZIP(p string) (io.ReadCloser, error) {
 		_, e = io.Copy(out, in)
 		return e
 	})
-	w.Close()
-	if err := ioutil.WriteFile("/tmp/pocowebc.zip", buf.Bytes(), 0666); err != nil {
-		return nil, err
+	if err != nil {
+		w.Close()
+		return err
 	}
-	return ioutil.NopCloser(&buf), err
+	return w.Close()
 }
 
 func newBookURL(c *api.Client) string {
@@ -177,11 +219,18 @@ var newUserCommand = cobra.Command{
 }
 
 func newUser(cmd *cobra.Command, args []string) error {
-	if newUserArgs.email == "" || newUserArgs.password == "" {
-		return fmt.Errorf("missing user email and/or password")
+	if newUserArgs.email == "" {
+		return fmt.Errorf("missing user email")
+	}
+	if newUserArgs.password == "" {
+		password, err := readNewPassword("Password")
+		if err != nil {
+			return fmt.Errorf("cannot create user %s: %v", newUserArgs.email, err)
+		}
+		newUserArgs.password = password
 	}
 	var newUser api.User
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	err := post(c, c.URL("users"), api.CreateUserRequest{
 		User: api.User{
 			Name:      newUserArgs.name,
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/finkf/gofiler"
+	"github.com/finkf/pcwgo/api"
+)
+
+var outputArgs = struct {
+	output  string
+	columns []string
+}{}
+
+// Supported output formats.
+var outputFormats = []string{"table", "csv", "tsv", "jsonl", "json"}
+
+// table is the tabular representation of the data printed by format.
+type table struct {
+	cols []string
+	rows [][]interface{}
+}
+
+func newTable(cols ...string) *table {
+	return &table{cols: cols}
+}
+
+func (t *table) add(vals ...interface{}) {
+	t.rows = append(t.rows, vals)
+}
+
+// tableOf returns the table of the given data.  It covers all types
+// handled by format.
+func tableOf(data interface{}) (*table, error) {
+	switch t := data.(type) {
+	case *api.Page:
+		return linesTable(t.Lines...), nil
+	case *api.Line:
+		return linesTable(*t), nil
+	case *api.Token:
+		return wordsTable(*t), nil
+	case *api.SearchResults:
+		return searchResultsTable(t), nil
+	case *api.CharMap:
+		tab := newTable("book", "project", "char", "count")
+		for _, char := range sortedKeys(t.CharMap) {
+			tab.add(t.BookID, t.ProjectID, char, t.CharMap[char])
+		}
+		return tab, nil
+	case *api.PostCorrection:
+		tab := newTable("id", "ocr", "cor", "confidence", "taken")
+		for _, key := range sortedKeys(t.Corrections) {
+			pc := t.Corrections[key]
+			tab.add(fmt.Sprintf("%d:%d:%d:%d", t.BookID, pc.PageID, pc.LineID, pc.TokenID),
+				pc.OCR, pc.Cor, pc.Confidence, pc.Taken)
+		}
+		return tab, nil
+	case api.Suggestions:
+		tab := newTable("project", "token", "suggestion", "modern",
+			"hist-patterns", "ocr-patterns", "dict", "distance", "weight", "top")
+		for _, key := range sortedKeys(t.Suggestions) {
+			for _, s := range t.Suggestions[key] {
+				tab.add(t.ProjectID, s.Token, s.Suggestion, s.Modern,
+					patterns(s.HistPatterns), patterns(s.OCRPatterns),
+					s.Dict, s.Distance, s.Weight, s.Top)
+			}
+		}
+		return tab, nil
+	case *api.SuggestionCounts:
+		tab := newTable("book", "project", "word", "count")
+		for _, word := range sortedKeys(t.Counts) {
+			tab.add(t.BookID, t.ProjectID, word, t.Counts[word])
+		}
+		return tab, nil
+	case *api.PatternCounts:
+		tab := newTable("book", "project", "pattern", "count", "ocr")
+		for _, pat := range sortedKeys(t.Counts) {
+			tab.add(t.BookID, t.ProjectID, pat, t.Counts[pat], t.OCR)
+		}
+		return tab, nil
+	case *api.AdaptiveTokens:
+		tab := newTable("book", "project", "token")
+		for _, token := range t.AdaptiveTokens {
+			tab.add(t.BookID, t.ProjectID, token)
+		}
+		return tab, nil
+	case *api.ExtendedLexicon:
+		tab := newTable("book", "project", "entry", "count", "yes")
+		for _, entry := range sortedKeys(t.Yes) {
+			tab.add(t.BookID, t.ProjectID, entry, t.Yes[entry], true)
+		}
+		for _, entry := range sortedKeys(t.No) {
+			tab.add(t.BookID, t.ProjectID, entry, t.No[entry], false)
+		}
+		return tab, nil
+	case gofiler.Profile:
+		tab := newTable("token", "suggestion", "modern", "hist-patterns",
+			"ocr-patterns", "dict", "distance", "weight", "top")
+		for _, token := range sortedKeys(t) {
+			for i, c := range t[token].Candidates {
+				tab.add(token, c.Suggestion, c.Modern, profilePatterns(c.HistPatterns),
+					profilePatterns(c.OCRPatterns), c.Dict, c.Distance, c.Weight, i == 0)
+			}
+		}
+		return tab, nil
+	case api.Session:
+		tab := newTable("id", "email", "name", "admin", "auth", "expires")
+		tab.add(t.User.ID, t.User.Email, t.User.Name, t.User.Admin, t.Auth,
+			time.Unix(t.Expires, 0).Format(time.RFC3339))
+		return tab, nil
+	case api.Version:
+		tab := newTable("version")
+		tab.add(t.Version)
+		return tab, nil
+	case *api.Users:
+		return usersTable(t.Users...), nil
+	case *api.User:
+		return usersTable(*t), nil
+	case *api.Books:
+		return booksTable(t.Books...), nil
+	case *api.Book:
+		return booksTable(*t), nil
+	case *config:
+		tab := newTable("name", "url", "skip-verify", "token", "default")
+		for _, name := range t.names() {
+			p := t.Profiles[name]
+			tab.add(name, p.URL, p.SkipVerify, p.Token != "", name == t.Default)
+		}
+		return tab, nil
+	case *validationReport:
+		tab := newTable("path", "formats", "images", "problems")
+		var formats, problems []string
+		for _, format := range sortedKeys(t.Formats) {
+			formats = append(formats, fmt.Sprintf("%s:%d", format, t.Formats[format]))
+		}
+		for _, p := range t.Problems {
+			problems = append(problems, p.String())
+		}
+		tab.add(t.Path, patterns(formats), t.Images, problemList(problems))
+		return tab, nil
+	case *api.JobStatus:
+		tab := newTable("job", "book", "name", "status", "time")
+		tab.add(t.JobID, t.BookID, t.JobName, t.StatusName,
+			t.Time().Format(time.RFC3339))
+		return tab, nil
+	case *applySummary:
+		tab := newTable("book", "applied", "would-apply", "skipped", "conflicts")
+		tab.add(t.BookID, t.Applied, t.WouldApply, t.Skipped, t.Conflicts)
+		return tab, nil
+	case *suggestionMatch:
+		tab := newTable("word", "suggestion", "ocr-patterns", "count")
+		tab.add(t.Word, t.Suggestion, patterns(t.OCRPatterns), t.Count)
+		return tab, nil
+	case *replacement:
+		tab := newTable("id", "old", "new")
+		tab.add(t.ID, t.Old, t.New)
+		return tab, nil
+	case *startReport:
+		tab := newTable("book", "job", "status", "duration", "error")
+		for _, res := range t.Results {
+			tab.add(res.BookID, res.Job, res.Status, res.Duration.String(), res.Error)
+		}
+		return tab, nil
+	default:
+		return nil, fmt.Errorf("invalid type to print: %T", t)
+	}
+}
+
+func linesTable(lines ...api.Line) *table {
+	if formatArgs.words {
+		var words []api.Token
+		for _, line := range lines {
+			if !formatArgs.onlyManual || line.IsManuallyCorrected {
+				words = append(words, line.Tokens...)
+			}
+		}
+		return wordsTable(words...)
+	}
+	tab := newTable("id", "cor", "ocr", "manual", "automatic")
+	for _, line := range lines {
+		if formatArgs.onlyManual && !line.IsManuallyCorrected {
+			continue
+		}
+		tab.add(line.ID(), line.Cor, line.OCR, line.IsManuallyCorrected,
+			line.IsAutomaticallyCorrected)
+	}
+	return tab
+}
+
+func wordsTable(words ...api.Token) *table {
+	tab := newTable("id", "cor", "ocr", "manual", "automatic")
+	for _, w := range words {
+		if formatArgs.onlyManual && !w.IsManuallyCorrected {
+			continue
+		}
+		tab.add(w.ID(), w.Cor, w.OCR, w.IsManuallyCorrected,
+			w.IsAutomaticallyCorrected)
+	}
+	return tab
+}
+
+func searchResultsTable(res *api.SearchResults) *table {
+	var lines []api.Line
+	for _, m := range res.Matches {
+		lines = append(lines, m.Lines...)
+	}
+	if !formatArgs.words {
+		return linesTable(lines...)
+	}
+	// Only the matched tokens are listed.
+	var words []api.Token
+	for _, line := range lines {
+		for _, w := range line.Tokens {
+			if w.IsMatch {
+				words = append(words, w)
+			}
+		}
+	}
+	return wordsTable(words...)
+}
+
+func usersTable(users ...api.User) *table {
+	tab := newTable("id", "name", "email", "institute", "admin")
+	for _, user := range users {
+		tab.add(user.ID, user.Name, user.Email, user.Institute, user.Admin)
+	}
+	return tab
+}
+
+func booksTable(books ...api.Book) *table {
+	tab := newTable("id", "book", "author", "title", "pages", "type", "status",
+		"year", "language", "profiler", "description")
+	for i := range books {
+		book := &books[i]
+		typ := "B"
+		if !book.IsBook {
+			typ = "P"
+		}
+		tab.add(book.ProjectID, book.BookID, book.Author, book.Title,
+			len(book.PageIDs), typ, bookStatusString(book), book.Year,
+			book.Language, book.ProfilerURL, book.Description)
+	}
+	return tab
+}
+
+// sortedKeys returns the sorted keys of the given map.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch t := m.(type) {
+	case map[string]int:
+		for k := range t {
+			keys = append(keys, k)
+		}
+	case map[string]bool:
+		for k := range t {
+			keys = append(keys, k)
+		}
+	case map[string]api.PostCorrectionToken:
+		for k := range t {
+			keys = append(keys, k)
+		}
+	case map[string][]api.Suggestion:
+		for k := range t {
+			keys = append(keys, k)
+		}
+	case gofiler.Profile:
+		for k := range t {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type problemList []string
+
+func (ps problemList) String() string {
+	return strings.Join(ps, "; ")
+}
+
+// selectColumns returns a table that only contains the given columns
+// in the given order.  If no columns are given, t is returned.
+func (t *table) selectColumns(cols []string) (*table, error) {
+	if len(cols) == 0 {
+		return t, nil
+	}
+	idx := make([]int, len(cols))
+	for i, col := range cols {
+		idx[i] = -1
+		for j := range t.cols {
+			if strings.EqualFold(col, t.cols[j]) {
+				idx[i] = j
+			}
+		}
+		if idx[i] == -1 {
+			return nil, fmt.Errorf("invalid column %q (available: %s)",
+				col, strings.Join(t.cols, ","))
+		}
+	}
+	sel := &table{cols: make([]string, len(cols))}
+	for i := range idx {
+		sel.cols[i] = t.cols[idx[i]]
+	}
+	for _, row := range t.rows {
+		vals := make([]interface{}, len(idx))
+		for i := range idx {
+			vals[i] = row[idx[i]]
+		}
+		sel.rows = append(sel.rows, vals)
+	}
+	return sel, nil
+}
+
+// output holds the state of the structured output.  Tables of the same
+// columns written by subsequent calls to format are merged into one
+// table with one header.  Aligned tables and json arrays are buffered
+// until flushOutput is called.  All structured output is written to
+// the shared writer w.
+var output = struct {
+	w       *bufio.Writer
+	cols    []string
+	header  bool
+	pending [][]interface{}
+}{}
+
+// outputWriter returns the shared writer of the structured output.  It
+// is flushed by flushOutput.
+func outputWriter() *bufio.Writer {
+	if output.w == nil {
+		output.w = bufio.NewWriter(os.Stdout)
+	}
+	return output.w
+}
+
+func checkOutputArgs() error {
+	if outputArgs.output == "" {
+		return nil
+	}
+	for _, f := range outputFormats {
+		if f == outputArgs.output {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid output format %q (available: %s)",
+		outputArgs.output, strings.Join(outputFormats, ","))
+}
+
+func formatMaybeOutput(data interface{}) bool {
+	if outputArgs.output == "" && len(outputArgs.columns) == 0 {
+		return false
+	}
+	t, err := tableOf(data)
+	if err == nil {
+		t, err = t.selectColumns(outputArgs.columns)
+	}
+	chk(err)
+	if !equalColumns(t.cols, output.cols) {
+		flushOutput()
+		output.cols = t.cols
+	}
+	switch outputArgs.output {
+	case "csv":
+		writeSeparated(t, ',')
+	case "tsv":
+		writeSeparated(t, '\t')
+	case "jsonl":
+		for _, row := range t.rows {
+			_, err := fmt.Fprintf(outputWriter(), "%s\n", jsonObject(t.cols, row))
+			chk(err)
+		}
+	default: // table and json
+		output.pending = append(output.pending, t.rows...)
+	}
+	return true
+}
+
+func writeSeparated(t *table, sep rune) {
+	w := csv.NewWriter(outputWriter())
+	w.Comma = sep
+	if !output.header {
+		chk(w.Write(t.cols))
+		output.header = true
+	}
+	for _, row := range t.rows {
+		chk(w.Write(textRow(row)))
+	}
+	w.Flush()
+	chk(w.Error())
+}
+
+// flushOutput writes the buffered output.  It must be called after
+// each command.
+func flushOutput() {
+	defer func() {
+		output.w, output.cols, output.header, output.pending = nil, nil, false, nil
+	}()
+	if output.cols != nil {
+		writePending()
+	}
+	if output.w != nil {
+		chk(output.w.Flush())
+	}
+}
+
+// writePending writes the buffered aligned table or json array.
+func writePending() {
+	switch outputArgs.output {
+	case "json":
+		objs := make([]json.RawMessage, len(output.pending))
+		for i, row := range output.pending {
+			objs[i] = jsonObject(output.cols, row)
+		}
+		if objs == nil {
+			objs = []json.RawMessage{}
+		}
+		chk(json.NewEncoder(outputWriter()).Encode(objs))
+	case "csv", "tsv", "jsonl":
+	default:
+		w := tabwriter.NewWriter(outputWriter(), 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(output.cols, "\t")))
+		for _, row := range output.pending {
+			fmt.Fprintln(w, strings.Join(textRow(row), "\t"))
+		}
+		chk(w.Flush())
+	}
+}
+
+// checkNoOutput returns an error if --output or --columns is given.
+// Interactive commands cannot format their output.
+func checkNoOutput(cmd string) error {
+	if outputArgs.output != "" || len(outputArgs.columns) > 0 {
+		return fmt.Errorf("%s: --output and --columns are not supported", cmd)
+	}
+	return nil
+}
+
+func textRow(row []interface{}) []string {
+	strs := make([]string, len(row))
+	for i, val := range row {
+		strs[i] = fmt.Sprint(val)
+	}
+	return strs
+}
+
+// jsonObject encodes the row as json object.  The keys are ordered
+// like the columns.
+func jsonObject(cols []string, row []interface{}) json.RawMessage {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i := range cols {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(cols[i])
+		chk(err)
+		val, err := json.Marshal(row[i])
+		chk(err)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+func equalColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
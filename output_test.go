@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/finkf/gofiler"
+	"github.com/finkf/pcwgo/api"
+)
+
+// captureStdout returns everything fn writes to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		done <- data
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	fn()
+	w.Close()
+	return string(<-done)
+}
+
+func TestTableOfAllFormatTypes(t *testing.T) {
+	// All types printed by format must have a table.
+	for _, data := range []interface{}{
+		&api.Page{}, &api.Line{}, &api.Token{}, &api.SearchResults{},
+		&api.CharMap{}, &api.PostCorrection{}, api.Suggestions{},
+		&api.SuggestionCounts{}, &api.PatternCounts{}, &api.AdaptiveTokens{},
+		&api.ExtendedLexicon{}, gofiler.Profile{}, api.Session{},
+		api.Version{}, &api.Users{}, &api.User{}, &api.Books{}, &api.Book{},
+		&config{}, &validationReport{}, &api.JobStatus{}, &startReport{},
+		&applySummary{}, &suggestionMatch{}, &replacement{},
+	} {
+		tab, err := tableOf(data)
+		if err != nil {
+			t.Errorf("%T: got error: %v", data, err)
+			continue
+		}
+		if len(tab.cols) == 0 {
+			t.Errorf("%T: no columns", data)
+		}
+		for _, row := range tab.rows {
+			if len(row) != len(tab.cols) {
+				t.Errorf("%T: expected %d values; got %d", data, len(tab.cols), len(row))
+			}
+		}
+	}
+	if _, err := tableOf(42); err == nil {
+		t.Errorf("int: expected error")
+	}
+}
+
+func TestSelectColumns(t *testing.T) {
+	tab := newTable("id", "ocr", "cor")
+	tab.add(1, "a", "b")
+	tab.add(2, "c", "d")
+	for _, tc := range []struct {
+		cols     []string
+		wantCols []string
+		wantRows [][]interface{}
+		err      bool
+	}{
+		{nil, []string{"id", "ocr", "cor"}, [][]interface{}{{1, "a", "b"}, {2, "c", "d"}}, false},
+		{[]string{"cor", "id"}, []string{"cor", "id"}, [][]interface{}{{"b", 1}, {"d", 2}}, false},
+		{[]string{"OCR"}, []string{"ocr"}, [][]interface{}{{"a"}, {"c"}}, false},
+		{[]string{"id", "id"}, []string{"id", "id"}, [][]interface{}{{1, 1}, {2, 2}}, false},
+		{[]string{"id", "text"}, nil, nil, true},
+	} {
+		t.Run(strings.Join(tc.cols, ","), func(t *testing.T) {
+			got, err := tab.selectColumns(tc.cols)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			if !reflect.DeepEqual(got.cols, tc.wantCols) {
+				t.Fatalf("expected columns %v; got %v", tc.wantCols, got.cols)
+			}
+			if !reflect.DeepEqual(got.rows, tc.wantRows) {
+				t.Fatalf("expected rows %v; got %v", tc.wantRows, got.rows)
+			}
+		})
+	}
+}
+
+func TestFormatOutput(t *testing.T) {
+	defer func() {
+		outputArgs.output, outputArgs.columns = "", nil
+	}()
+	books := []api.Book{
+		{ProjectID: 1, Title: "a, b", IsBook: true},
+		{ProjectID: 2, Title: "c", IsBook: true},
+	}
+	for _, tc := range []struct {
+		output string
+		want   string
+	}{
+		{"table", "ID  TITLE\n1   a, b\n2   c\n"},
+		{"csv", "id,title\n1,\"a, b\"\n2,c\n"},
+		{"tsv", "id\ttitle\n1\ta, b\n2\tc\n"},
+		{"jsonl", `{"id":1,"title":"a, b"}` + "\n" + `{"id":2,"title":"c"}` + "\n"},
+		{"json", `[{"id":1,"title":"a, b"},{"id":2,"title":"c"}]` + "\n"},
+	} {
+		t.Run(tc.output, func(t *testing.T) {
+			outputArgs.output, outputArgs.columns = tc.output, []string{"id", "title"}
+			if err := checkOutputArgs(); err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			// Subsequent calls with the same columns are merged
+			// into one table.
+			got := captureStdout(t, func() {
+				for i := range books {
+					format(&books[i])
+				}
+				flushOutput()
+			})
+			if got != tc.want {
+				t.Fatalf("expected %q; got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFormatOutputEmptyJSON(t *testing.T) {
+	defer func() {
+		outputArgs.output, outputArgs.columns = "", nil
+	}()
+	outputArgs.output = "json"
+	got := captureStdout(t, func() {
+		format(&api.Books{})
+		flushOutput()
+	})
+	if want := "[]\n"; got != want {
+		t.Fatalf("expected %q; got %q", want, got)
+	}
+}
+
+func TestCheckOutputArgs(t *testing.T) {
+	defer func() {
+		outputArgs.output = ""
+	}()
+	for _, tc := range []struct {
+		output string
+		err    bool
+	}{
+		{"", false},
+		{"table", false},
+		{"json", false},
+		{"xml", true},
+	} {
+		outputArgs.output = tc.output
+		if err := checkOutputArgs(); (err != nil) != tc.err {
+			t.Errorf("%q: expected error %t; got %v", tc.output, tc.err, err)
+		}
+	}
+}
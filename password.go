@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var passwordArgs = struct {
+	file  string
+	stdin bool
+}{}
+
+// addPasswordFlags adds the --password-stdin and --password-file
+// flags to the given flag set.  Use it for every command that needs
+// to read a password.
+func addPasswordFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&passwordArgs.stdin, "password-stdin", false,
+		"read the password from stdin")
+	flags.StringVar(&passwordArgs.file, "password-file", "",
+		"read the password from the given file")
+}
+
+// readPassword reads a password.  The password is read from the file
+// given with --password-file, from stdin if --password-stdin is given
+// or from an interactive prompt on the terminal.
+func readPassword(prompt string) (string, error) {
+	return readPasswordConfirm(prompt, false)
+}
+
+// readNewPassword reads a new password.  If the password is read from
+// the terminal, the user has to enter it twice.
+func readNewPassword(prompt string) (string, error) {
+	return readPasswordConfirm(prompt, true)
+}
+
+func readPasswordConfirm(prompt string, confirm bool) (string, error) {
+	if passwordArgs.stdin && passwordArgs.file != "" {
+		return "", fmt.Errorf("--password-stdin and --password-file are mutually exclusive")
+	}
+	if passwordArgs.file != "" {
+		data, err := ioutil.ReadFile(passwordArgs.file)
+		if err != nil {
+			return "", fmt.Errorf("read password: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if passwordArgs.stdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("read password from stdin: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", fmt.Errorf("read password: stdin is not a terminal: " +
+			"use --password-stdin or --password-file")
+	}
+	password, err := promptPassword(fd, prompt)
+	if err != nil {
+		return "", err
+	}
+	if !confirm {
+		return password, nil
+	}
+	again, err := promptPassword(fd, "Repeat "+strings.ToLower(prompt))
+	if err != nil {
+		return "", err
+	}
+	if password != again {
+		return "", fmt.Errorf("read password: passwords do not match")
+	}
+	return password, nil
+}
+
+func promptPassword(fd int, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt+": ")
+	password, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("read password: %v", err)
+	}
+	return string(password), nil
+}
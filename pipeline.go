@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/finkf/pcwgo/api"
+	"github.com/spf13/cobra"
+)
+
+var pipelineArgs = struct {
+	steps []string
+	then  string
+	force bool
+}{}
+
+func init() {
+	pipelineCommand.Flags().StringSliceVar(&pipelineArgs.steps, "steps",
+		[]string{"profile", "el", "rrdm"}, "set the steps to run")
+	pipelineCommand.Flags().StringVar(&pipelineArgs.then, "then", "",
+		"run the given action after all steps (download)")
+	pipelineCommand.Flags().BoolVarP(&pipelineArgs.force, "force", "f", false,
+		"run steps that are already done")
+	pipelineCommand.Flags().StringVarP(&downloadArgs.out, "out", "o", "",
+		"set output file of the download (default: stdout)")
+	pipelineCommand.Flags().IntVarP(&startArgs.sleep, "sleep", "s", 5,
+		"set the maximal number of seconds to sleep between checks if a job has finished")
+	pipelineCommand.Flags().DurationVar(&startArgs.timeout, "wait-timeout", 0,
+		"give up waiting after DURATION (0: wait forever)")
+}
+
+var pipelineCommand = cobra.Command{
+	Use:   "pipeline ID",
+	Short: "Run the profile, el and rrdm jobs on book ID",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runPipeline,
+	Long: `
+Run the given steps on book ID one after another.  The available
+steps are profile, el (extended lexicon) and rrdm (automatic
+post-correction).  Steps that are already done according to the
+status of the book are skipped unless --force is given.  If a job of
+the book is still running, the pipeline waits for it instead of
+starting it again.  So an interrupted pipeline can be resumed with the
+same command.
+
+Use --then download to download the book after all steps are done.
+The time of each step is reported on stderr.`,
+}
+
+func runPipeline(_ *cobra.Command, args []string) error {
+	ids, err := parseID(args[0], bookLevels[:1])
+	if err != nil {
+		return fmt.Errorf("pipeline: %v", err)
+	}
+	bid := ids[0]
+	var steps []jobStep
+	for _, name := range pipelineArgs.steps {
+		step, ok := findJobStep(name)
+		if !ok {
+			return fmt.Errorf("pipeline: invalid step: %q", name)
+		}
+		steps = append(steps, step)
+	}
+	switch pipelineArgs.then {
+	case "", "download":
+	default:
+		return fmt.Errorf("pipeline: invalid action: %q", pipelineArgs.then)
+	}
+	c := authenticate()
+	started := time.Now()
+	for _, step := range steps {
+		if err := runPipelineStep(c, bid, step); err != nil {
+			return fmt.Errorf("pipeline book %d: %s: %w", bid, step.name, err)
+		}
+	}
+	if pipelineArgs.then == "download" {
+		t := time.Now()
+		if err := download(c, c.URL("books/%d/download", bid)); err != nil {
+			return fmt.Errorf("pipeline book %d: download: %v", bid, err)
+		}
+		reportPipelineStep("download", time.Since(t))
+	}
+	reportPipelineStep("pipeline", time.Since(started))
+	return nil
+}
+
+// runPipelineStep runs the given step.  The book is fetched before
+// each step, so the step is skipped if it was done in an earlier run.
+func runPipelineStep(c *api.Client, bid int, step jobStep) error {
+	var book api.Book
+	if err := get(c, c.URL("books/%d", bid), &book); err != nil {
+		return err
+	}
+	if book.Status[step.status] && !pipelineArgs.force {
+		fmt.Fprintf(os.Stderr, "%s: skipped (already %s)\n", step.name, step.status)
+		return nil
+	}
+	t := time.Now()
+	if err := step.run(c, bid); err != nil {
+		return err
+	}
+	reportPipelineStep(step.name, time.Since(t))
+	return nil
+}
+
+func reportPipelineStep(name string, d time.Duration) {
+	fmt.Fprintf(os.Stderr, "%s: done in %s\n", name, d.Round(time.Millisecond))
+}
@@ -38,7 +38,7 @@ func doAssign(_ *cobra.Command, args []string) error {
 		}
 		ids = append(ids, id)
 	}
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	var err error
 	switch len(ids) {
 	case 2:
@@ -63,11 +63,12 @@ are to the owner of the project.`,
 }
 
 func doReassign(cmd *cobra.Command, args []string) error {
-	var pid int
-	if n := parseIDs(args[0], &pid); n != 1 {
-		return fmt.Errorf("cannot reassign: invalid id: %s", args[0])
+	ids, err := parseID(args[0], packageLevels)
+	if err != nil {
+		return fmt.Errorf("cannot reassign: %v", err)
 	}
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	pid := ids[0]
+	c := authenticate()
 	if err := get(c, c.URL("pkg/takeback/books/%d", pid), nil); err != nil {
 		return fmt.Errorf("cannot reassign package %d: %v", pid, err)
 	}
@@ -94,7 +95,7 @@ and the third by user 3.`,
 }
 
 func doSplit(cmd *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	var ids []int
 	for _, arg := range args {
 		id, err := strconv.Atoi(arg)
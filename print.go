@@ -11,7 +11,13 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var pageArgs = struct {
+	jobs int
+}{}
+
 func init() {
+	printCommand.Flags().IntVarP(&pageArgs.jobs, "jobs", "j", 4,
+		"fetch up to N pages concurrently")
 	printCommand.Flags().BoolVarP(&formatArgs.words, "words", "w", false,
 		"print words not lines")
 	printCommand.Flags().BoolVarP(&formatArgs.ocr, "ocr", "o", false,
@@ -26,10 +32,15 @@ var printCommand = cobra.Command{
 	Use:   "print IDs...",
 	Short: "Print books, pages, lines and/or words",
 	RunE:  printIDs,
+	Long: `
+Print books, pages, lines and/or words.  IDs have the form
+BOOK[:PAGE[:LINE[:WORD[:LEN]]]].  Each component can be a single ID,
+a range (3-40), a list (3,5,9), the keywords first and last or the
+wildcard *.  If no IDs are given, IDs are read from stdin.`,
 }
 
 func printIDs(_ *cobra.Command, args []string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	for _, id := range args {
 		if err := doPrintID(c, id); err != nil {
 			return err
@@ -48,41 +59,156 @@ func printIDs(_ *cobra.Command, args []string) error {
 }
 
 func doPrintID(c *api.Client, id string) error {
-	var bid, pid, lid, wid, len, mod int
-	id, mod = getMod(id)
-	switch n := parseIDs(id, &bid, &pid, &lid, &wid, &len); n {
-	case 5:
-		return getWord(c, bid, pid, lid, wid, len)
-	case 4:
-		return getWord(c, bid, pid, lid, wid, -1)
-	case 3:
-		return getLine(c, bid, pid, lid)
-	case 2:
-		_, _, err := getPage(c, bid, pid, mod)
-		return err
-	case 1:
-		return getPages(c, bid)
-	default:
-		return fmt.Errorf("invalid id: %s", id)
-	}
+	id, mod := getMod(id)
+	return eachID(c, id, bookLevels, func(ids []int) error {
+		switch len(ids) {
+		case 5:
+			return getWord(c, ids[0], ids[1], ids[2], ids[3], ids[4])
+		case 4:
+			return getWord(c, ids[0], ids[1], ids[2], ids[3], -1)
+		case 3:
+			return getLine(c, ids[0], ids[1], ids[2])
+		case 2:
+			_, _, err := getPage(c, ids[0], ids[1], mod)
+			return err
+		default:
+			return getPages(c, ids[0])
+		}
+	})
 }
 
 func getPages(c *api.Client, bid int) error {
+	err := eachPage(c, bid, inOrder(func(p *api.Page) error {
+		format(p)
+		return nil
+	}))
+	if err != nil {
+		return fmt.Errorf("get pages: %v", err)
+	}
+	return nil
+}
+
+// pageFunc is called for each fetched page.  If the pages are fetched
+// concurrently, it is called by the fetching workers.  The returned
+// function is called in page order.
+type pageFunc func(*api.Page) (func() error, error)
+
+// inOrder returns a pageFunc that calls fn in page order.
+func inOrder(fn func(*api.Page) error) pageFunc {
+	return func(p *api.Page) (func() error, error) {
+		return func() error { return fn(p) }, nil
+	}
+}
+
+// eachPage calls fn for each page of the book.  If the page IDs of the
+// book are known, up to --jobs pages are fetched concurrently.
+func eachPage(c *api.Client, bid int, fn pageFunc) error {
+	if pageArgs.jobs > 1 {
+		var book api.Book
+		if err := get(c, c.URL("books/%d", bid), &book); err != nil {
+			return err
+		}
+		if len(book.PageIDs) > 0 {
+			return eachPageConcurrently(c, bid, book.PageIDs, pageArgs.jobs, fn)
+		}
+	}
 	pageid := 0
 	for {
-		next, _, err := getPage(c, bid, pageid, 0)
+		p, err := fetchPage(c, bid, pageid, 0)
 		if err != nil {
-			return fmt.Errorf("get pages: %v", err)
+			return err
+		}
+		then, err := fn(p)
+		if err != nil {
+			return err
 		}
-		if next == pageid {
+		if err := then(); err != nil {
+			return err
+		}
+		if p.NextPageID == p.PageID || p.NextPageID == 0 {
 			break
 		}
-		pageid = next
+		pageid = p.NextPageID
+	}
+	return nil
+}
+
+// pageResult is the result of fetching a page.  Then is the function
+// returned by the pageFunc of the page.
+type pageResult struct {
+	then func() error
+	err  error
+}
+
+// eachPageConcurrently fetches the given pages using n workers that
+// call fn for each page.  The functions returned by fn are called in
+// the order of the page IDs.  At most 2*n pages are fetched ahead.
+func eachPageConcurrently(c *api.Client, bid int, pids []int, n int, fn pageFunc) error {
+	results := make([]chan pageResult, len(pids))
+	for i := range results {
+		results[i] = make(chan pageResult, 1)
+	}
+	window := make(chan struct{}, 2*n)
+	todo := make(chan int)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(todo)
+		for i := range pids {
+			select {
+			case window <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case todo <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for j := 0; j < n; j++ {
+		go func() {
+			for i := range todo {
+				results[i] <- fetchPageResult(c, bid, pids[i], fn)
+			}
+		}()
+	}
+	for i := range results {
+		res := <-results[i]
+		if res.err != nil {
+			return res.err
+		}
+		if err := res.then(); err != nil {
+			return err
+		}
+		<-window
 	}
 	return nil
 }
 
+// fetchPageResult fetches the given page and calls fn in a worker of
+// eachPageConcurrently.
+func fetchPageResult(c *api.Client, bid, pid int, fn pageFunc) (res pageResult) {
+	defer recoverAbort(&res.err)
+	p, err := fetchPage(c, bid, pid, 0)
+	if err != nil {
+		return pageResult{err: err}
+	}
+	res.then, res.err = fn(p)
+	return res
+}
+
 func getPage(c *api.Client, bid, pid, mod int) (int, int, error) {
+	p, err := fetchPage(c, bid, pid, mod)
+	if err != nil {
+		return 0, 0, err
+	}
+	format(p)
+	return p.NextPageID, p.PrevPageID, nil
+}
+
+func fetchPage(c *api.Client, bid, pid, mod int) (*api.Page, error) {
 	var url string
 	switch pid {
 	case 0:
@@ -95,14 +221,13 @@ func getPage(c *api.Client, bid, pid, mod int) (int, int, error) {
 	}
 	var p api.Page
 	if err := get(c, url, &p); err != nil {
-		return 0, 0, fmt.Errorf("get page: %v", err)
+		return nil, fmt.Errorf("get page: %v", err)
 	}
-	format(&p)
-	return p.NextPageID, p.PrevPageID, nil
+	return &p, nil
 }
 
 func getLine(c *api.Client, bid, pid, lid int) error {
-	url := fmt.Sprintf("%s/books/%d/pages/%d/lines/%d", c.Host, bid, pid, lid)
+	url := c.URL("books/%d/pages/%d/lines/%d", bid, pid, lid)
 	var line api.Line
 	if err := get(c, url, &line); err != nil {
 		return fmt.Errorf("get line: %v", err)
@@ -119,12 +244,12 @@ func getWord(c *api.Client, bid, pid, lid, wid, len int) error {
 			bid, pid, lid, wid)
 	default:
 		url = c.URL("books/%d/pages/%d/lines/%d/tokens/%d?len=%d",
-			c.Host, bid, pid, lid, wid, len)
+			bid, pid, lid, wid, len)
 
 	}
 	var token api.Token
 	if err := get(c, url, &token); err != nil {
-		return fmt.Errorf("get word: %v")
+		return fmt.Errorf("get word: %v", err)
 	}
 	format(&token)
 	return nil
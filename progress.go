@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/finkf/pcwgo/api"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// progressReader reports the number of bytes read from the wrapped
+// reader on stderr.  Progress is only shown if stderr is a terminal.
+type progressReader struct {
+	r     io.Reader
+	name  string
+	n     int64
+	total int64 // total number of bytes; -1 if unknown
+	last  time.Time
+	tty   bool
+}
+
+func newProgressReader(r io.Reader, name string, total int64) *progressReader {
+	return &progressReader{
+		r:     r,
+		name:  name,
+		total: total,
+		tty:   terminal.IsTerminal(int(os.Stderr.Fd())),
+	}
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.n += int64(n)
+	if pr.tty && time.Since(pr.last) >= 200*time.Millisecond {
+		pr.last = time.Now()
+		pr.report()
+	}
+	return n, err
+}
+
+// Done writes the final progress line.
+func (pr *progressReader) Done() {
+	if !pr.tty {
+		return
+	}
+	pr.report()
+	fmt.Fprintln(os.Stderr)
+}
+
+func (pr *progressReader) report() {
+	if pr.total < 0 {
+		fmt.Fprintf(os.Stderr, "\r%s: %s", pr.name, byteSize(pr.n))
+		return
+	}
+	var percent float64 = 100
+	if pr.total > 0 {
+		percent = float64(pr.n) * 100 / float64(pr.total)
+	}
+	fmt.Fprintf(os.Stderr, "\r%s: %s / %s (%.1f%%)", pr.name,
+		byteSize(pr.n), byteSize(pr.total), percent)
+}
+
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// jobProgress reports the status of a job while waiting for it on
+// stderr.  On terminals a status line with the job name, the elapsed
+// time and the status is updated in place and every state transition
+// is kept on its own line.  Otherwise a log line is written on every
+// state transition and periodically while the job is running.
+type jobProgress struct {
+	jobID   int
+	started time.Time
+	last    *api.JobStatus
+	logged  time.Time
+	tty     bool
+	drawn   bool // the status line is shown
+}
+
+// jobLogInterval is the interval of the log lines written while a job
+// is running if stderr is not a terminal.
+const jobLogInterval = 30 * time.Second
+
+// jobProgressLog forces log lines on terminals.  It is set if
+// multiple jobs are waited for at the same time.
+var jobProgressLog bool
+
+func newJobProgress(jobID int) *jobProgress {
+	return &jobProgress{
+		jobID:   jobID,
+		started: time.Now(),
+		tty:     !jobProgressLog && terminal.IsTerminal(int(os.Stderr.Fd())),
+	}
+}
+
+// update reports the given status.  It returns true if the status of
+// the job changed since the last update.
+func (jp *jobProgress) update(status *api.JobStatus) bool {
+	changed := jp.last == nil || jp.last.StatusID != status.StatusID
+	old := jp.last
+	jp.last = status
+	switch {
+	case jp.tty && changed && old != nil:
+		fmt.Fprintf(os.Stderr, "\r%s\x1b[K\n", jp.transition(old, status))
+		jp.drawn = false
+		if jobRunning(status) {
+			jp.redraw()
+		}
+	case jp.tty:
+		jp.redraw()
+	case changed && old != nil:
+		log.Print(jp.transition(old, status))
+		jp.logged = time.Now()
+	case changed || time.Since(jp.logged) >= jobLogInterval:
+		log.Print(jp.line())
+		jp.logged = time.Now()
+	}
+	return changed
+}
+
+// sleep sleeps for the given duration.  A shown status line is
+// redrawn every second.
+func (jp *jobProgress) sleep(d time.Duration) {
+	for d > 0 && jp.drawn {
+		step := time.Second
+		if d < step {
+			step = d
+		}
+		time.Sleep(step)
+		d -= step
+		jp.redraw()
+	}
+	time.Sleep(d)
+}
+
+// Done terminates the status line.
+func (jp *jobProgress) Done() {
+	if jp.drawn {
+		jp.redraw()
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func (jp *jobProgress) redraw() {
+	fmt.Fprintf(os.Stderr, "\r%s\x1b[K", jp.line())
+	jp.drawn = true
+}
+
+func (jp *jobProgress) line() string {
+	return fmt.Sprintf("%s: %s (%s)", jp.name(), jp.last.StatusName, jp.elapsed())
+}
+
+func (jp *jobProgress) transition(old, status *api.JobStatus) string {
+	return fmt.Sprintf("%s: %s -> %s (%s)", jp.name(), old.StatusName,
+		status.StatusName, jp.elapsed())
+}
+
+func (jp *jobProgress) name() string {
+	if jp.last.JobName == "" {
+		return fmt.Sprintf("job %d", jp.jobID)
+	}
+	return fmt.Sprintf("job %d %s", jp.jobID, jp.last.JobName)
+}
+
+func (jp *jobProgress) elapsed() time.Duration {
+	return time.Since(jp.started).Round(time.Second)
+}
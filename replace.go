@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/finkf/pcwgo/api"
+	"github.com/spf13/cobra"
+)
+
+var replaceArgs = struct {
+	typ    string
+	corTyp string
+	dryRun bool
+	yes    bool
+	ic     bool
+}{}
+
+func init() {
+	replaceCommand.Flags().StringVarP(&replaceArgs.typ, "type", "t",
+		"token", "set search type (token|regex|pattern)")
+	replaceCommand.Flags().StringVarP(&replaceArgs.corTyp, "correction-type", "T",
+		"manual", "set correction type")
+	replaceCommand.Flags().BoolVarP(&replaceArgs.dryRun, "dry-run", "n",
+		false, "only print the corrections")
+	replaceCommand.Flags().BoolVarP(&replaceArgs.yes, "yes", "y",
+		false, "apply all corrections without asking")
+	replaceCommand.Flags().BoolVarP(&replaceArgs.ic, "ignore-case", "i",
+		false, "ignore case for search")
+}
+
+var replaceCommand = cobra.Command{
+	Use:   "replace ID QUERY REPLACEMENT",
+	Short: "Search and replace tokens in book ID",
+	Args:  cobra.ExactArgs(3),
+	RunE:  runReplace,
+	Long: `
+Search for QUERY in book ID and correct all matching tokens.  For
+token searches, the matched tokens are replaced with REPLACEMENT.
+For regex searches, all matches of the regular expression QUERY in
+the matched tokens are replaced with REPLACEMENT ($1 etc. expand to
+the submatches).  For pattern searches, all occurrences of QUERY in
+the matched tokens are replaced with REPLACEMENT.
+
+Each correction has to be confirmed interactively: (y)es, (n)o,
+(a)ll remaining or (q)uit.  Use --yes to apply all corrections and
+--dry-run to only print them.`,
+}
+
+// replacement defines a single correction of a replace run.
+type replacement struct {
+	ID  string `json:"id"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+func runReplace(_ *cobra.Command, args []string) error {
+	ids, err := parseID(args[0], bookLevels[:1])
+	if err != nil {
+		return fmt.Errorf("replace: %v", err)
+	}
+	bid := ids[0]
+	if !replaceArgs.yes && !replaceArgs.dryRun {
+		if err := checkNoOutput("replace"); err != nil {
+			return fmt.Errorf("%v without --yes or --dry-run", err)
+		}
+	}
+	u := unescape(args[1:]...)
+	replace, err := replacer(replaceArgs.typ, u[0], u[1])
+	if err != nil {
+		return fmt.Errorf("replace: %v", err)
+	}
+	c := authenticate()
+	rs, err := searchReplacements(c, bid, replaceArgs.typ, u[0], replaceArgs.ic, replace)
+	if err != nil {
+		return fmt.Errorf("replace in book %d: %v", bid, err)
+	}
+	in := bufio.NewReader(os.Stdin)
+	all := replaceArgs.yes
+	for _, r := range rs {
+		if replaceArgs.dryRun {
+			format(&r)
+			continue
+		}
+		if !all {
+			answer, err := confirmReplacement(in, r)
+			if err != nil {
+				return fmt.Errorf("replace in book %d: %v", bid, err)
+			}
+			switch answer {
+			case 'n':
+				continue
+			case 'q':
+				return nil
+			case 'a':
+				all = true
+			}
+		}
+		resp, err := applyCorrection(c, r.ID, replaceArgs.corTyp, r.New)
+		if err != nil {
+			return fmt.Errorf("replace in book %d: correct %s: %v", bid, r.ID, err)
+		}
+		format(resp)
+	}
+	return nil
+}
+
+// replacer returns a function that calculates the correction for a
+// matched token.
+func replacer(typ, query, replacement string) (func(string) string, error) {
+	switch api.SearchType(typ) {
+	case api.SearchToken:
+		return func(string) string { return replacement }, nil
+	case api.SearchRegex:
+		if replaceArgs.ic {
+			query = "(?i)" + query
+		}
+		re, err := regexp.Compile(query)
+		if err != nil {
+			return nil, err
+		}
+		return func(str string) string {
+			return re.ReplaceAllString(str, replacement)
+		}, nil
+	case api.SearchPattern:
+		return func(str string) string {
+			return strings.Replace(str, query, replacement, -1)
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid search type: %q", typ)
+	}
+}
+
+// searchReplacements collects all matches for the query before any
+// correction is applied, since corrections change the search results.
+func searchReplacements(c *api.Client, bid int, typ, q string, ic bool,
+	replace func(string) string) ([]replacement, error) {
+	const max = 50
+	var rs []replacement
+	seen := make(map[string]bool)
+	for skip := 0; ; skip += max {
+		uri := c.URL("books/%d/search?i=%t&max=%d&skip=%d&type=%s&q=%s",
+			bid, ic, max, skip, url.QueryEscape(typ),
+			url.QueryEscape(q))
+		var results api.SearchResults
+		if err := get(c, uri, &results); err != nil {
+			return nil, err
+		}
+		if !hasAnyMatches(&results) {
+			return rs, nil
+		}
+		for _, m := range results.Matches {
+			for _, line := range m.Lines {
+				for _, t := range line.Tokens {
+					id := t.ID()
+					if !t.IsMatch || seen[id] {
+						continue
+					}
+					seen[id] = true
+					if cor := replace(t.Cor); cor != t.Cor {
+						rs = append(rs, replacement{ID: id, Old: t.Cor, New: cor})
+					}
+				}
+			}
+		}
+	}
+}
+
+func confirmReplacement(in *bufio.Reader, r replacement) (byte, error) {
+	for {
+		fmt.Fprintf(os.Stderr, "%s %s -> %s? [y,n,a,q] ", r.ID, s(r.Old), s(r.New))
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return 0, fmt.Errorf("read answer: %v", err)
+		}
+		switch answer := strings.TrimSpace(strings.ToLower(line)); answer {
+		case "y", "yes":
+			return 'y', nil
+		case "n", "no":
+			return 'n', nil
+		case "a", "all":
+			return 'a', nil
+		case "q", "quit":
+			return 'q', nil
+		}
+	}
+}
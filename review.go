@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/finkf/pcwgo/api"
+	"github.com/spf13/cobra"
+)
+
+var reviewArgs = struct {
+	restart bool
+}{}
+
+func init() {
+	reviewCommand.Flags().BoolVarP(&reviewArgs.restart, "restart", "r", false,
+		"ignore the saved position and start at the first page")
+}
+
+var reviewCommand = cobra.Command{
+	Use:   "review ID",
+	Short: "Interactively correct book ID",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runReview,
+	Long: `
+Interactively correct book ID line by line.  For each line, the ocr
+and the corrected text are shown.  Then the session steps through all
+words of the line that are not manually corrected and shows the
+profiler suggestions for the current word.  The following commands
+are available:
+
+  N        accept suggestion N
+  =TEXT    correct the word to TEXT
+  (enter)  skip the word
+  n        skip to the next line
+  p        go back to the previous line
+  q        quit the session
+
+All corrections are manual corrections.  The position is saved, so an
+interrupted session can be resumed with the same command.  Use
+--restart to start again at the first page.`,
+}
+
+// reviewPosition is the saved position of a review session.
+type reviewPosition struct {
+	Host    string `json:"host"`
+	BookID  int    `json:"bookId"`
+	PageID  int    `json:"pageId"`
+	LineID  int    `json:"lineId"`
+	TokenID int    `json:"tokenId"`
+}
+
+// review holds the state of a review session.
+type review struct {
+	c     *api.Client
+	in    *bufio.Reader
+	pos   reviewPosition
+	page  *api.Page
+	line  int // index of the current line
+	suggs map[string][]api.Suggestion
+}
+
+// errQuit is returned if the user quits the review session.
+var errQuit = fmt.Errorf("quit")
+
+func runReview(_ *cobra.Command, args []string) error {
+	if err := checkNoOutput("review"); err != nil {
+		return err
+	}
+	ids, err := parseID(args[0], bookLevels[:1])
+	if err != nil {
+		return fmt.Errorf("review: %v", err)
+	}
+	bid := ids[0]
+	c := authenticate()
+	r := review{
+		c:     c,
+		in:    bufio.NewReader(os.Stdin),
+		pos:   reviewPosition{Host: c.Host, BookID: bid},
+		suggs: make(map[string][]api.Suggestion),
+	}
+	if !reviewArgs.restart {
+		if err := r.pos.read(); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("review book %d: %v", bid, err)
+		}
+	}
+	if err := r.run(); err != nil {
+		return fmt.Errorf("review book %d: %v", bid, err)
+	}
+	return nil
+}
+
+func (r *review) run() error {
+	p, err := fetchPage(r.c, r.pos.BookID, r.pos.PageID, 0)
+	if err != nil {
+		return err
+	}
+	r.page = p
+	for i := range p.Lines {
+		if p.Lines[i].LineID == r.pos.LineID {
+			r.line = i
+		}
+	}
+	for {
+		if r.line >= len(r.page.Lines) {
+			ok, err := r.nextPage()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				printf(nil, "end of book\n")
+				return r.pos.remove()
+			}
+			continue
+		}
+		next, err := r.reviewLine(&r.page.Lines[r.line])
+		if err == errQuit {
+			return r.pos.write()
+		}
+		if err != nil {
+			if werr := r.pos.write(); werr != nil {
+				return fmt.Errorf("%v (write position: %v)", err, werr)
+			}
+			return err
+		}
+		r.pos.TokenID = 0
+		r.line += next
+		if r.line < 0 {
+			ok, err := r.prevPage()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				r.line = 0
+			}
+		}
+		if r.line < len(r.page.Lines) {
+			r.pos.LineID = r.page.Lines[r.line].LineID
+		}
+		if err := r.pos.write(); err != nil {
+			return err
+		}
+	}
+}
+
+// nextPage loads the next page.  It returns false if the current
+// page is the last page of the book.
+func (r *review) nextPage() (bool, error) {
+	if r.page.NextPageID == r.page.PageID || r.page.NextPageID == 0 {
+		return false, nil
+	}
+	p, err := fetchPage(r.c, r.pos.BookID, r.page.PageID, 1)
+	if err != nil {
+		return false, err
+	}
+	r.page, r.line = p, 0
+	r.pos.PageID = p.PageID
+	if len(p.Lines) > 0 {
+		r.pos.LineID = p.Lines[0].LineID
+	}
+	return true, nil
+}
+
+// prevPage loads the previous non empty page and moves to its last
+// line.  It returns false if there is no such page.
+func (r *review) prevPage() (bool, error) {
+	page := r.page
+	for {
+		if page.PrevPageID == page.PageID || page.PrevPageID == 0 {
+			return false, nil
+		}
+		p, err := fetchPage(r.c, r.pos.BookID, page.PageID, -1)
+		if err != nil {
+			return false, err
+		}
+		if len(p.Lines) > 0 {
+			r.page, r.line = p, len(p.Lines)-1
+			r.pos.PageID = p.PageID
+			return true, nil
+		}
+		page = p
+	}
+}
+
+// reviewLine reviews the words of the given line.  It returns the
+// offset of the next line to review.
+func (r *review) reviewLine(line *api.Line) (int, error) {
+	printf(nil, "\n%d:%d:%d\n", line.ProjectID, line.PageID, line.LineID)
+	printf(nil, "ocr:")
+	for _, t := range line.Tokens {
+		printf(nil, " %s", t.OCR)
+	}
+	printf(nil, "\ncor:")
+	for i := range line.Tokens {
+		printf(nil, " ")
+		printf(colorForToken(&line.Tokens[i]), "%s", line.Tokens[i].Cor)
+	}
+	printf(nil, "\n")
+	for i := range line.Tokens {
+		t := &line.Tokens[i]
+		if t.TokenID < r.pos.TokenID || t.IsManuallyCorrected {
+			continue
+		}
+		r.pos.TokenID = t.TokenID
+		next, err := r.reviewToken(t)
+		if err != nil || next != 0 {
+			return next, err
+		}
+	}
+	return 1, nil
+}
+
+// reviewToken asks for a correction of the given token.  It returns
+// the offset of the next line to review or 0 to continue with the
+// next token of the current line.
+func (r *review) reviewToken(t *api.Token) (int, error) {
+	suggs, err := r.suggestions(t.OCR)
+	if err != nil {
+		return 0, err
+	}
+	printf(nil, "  ")
+	printf(colorForToken(t), "%s", t.Cor)
+	printf(nil, " (%s)\n", t.OCR)
+	for i, sugg := range suggs {
+		printf(nil, "    %d) %s %s %d %f\n", i+1, sugg.Suggestion,
+			patterns(sugg.OCRPatterns), sugg.Distance, sugg.Weight)
+	}
+	for {
+		printf(nil, "  [N,=TEXT,n,p,q]> ")
+		answer, err := r.in.ReadString('\n')
+		if err == io.EOF && answer == "" {
+			return 0, errQuit
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		answer = strings.TrimRight(answer, "\r\n")
+		switch answer {
+		case "":
+			return 0, nil
+		case "n":
+			return 1, nil
+		case "p":
+			return -1, nil
+		case "q":
+			return 0, errQuit
+		}
+		if strings.HasPrefix(answer, "=") {
+			return 0, r.correct(t, answer[1:])
+		}
+		if i, err := strconv.Atoi(answer); err == nil && i > 0 && i <= len(suggs) {
+			return 0, r.correct(t, matchCase(t.Cor, suggs[i-1].Suggestion))
+		}
+		printf(nil, "  invalid command: %s\n", answer)
+	}
+}
+
+func (r *review) correct(t *api.Token, cor string) error {
+	id := fmt.Sprintf("%d:%d:%d:%d", r.pos.BookID, t.PageID, t.LineID, t.TokenID)
+	resp, err := applyCorrection(r.c, id, "manual", cor)
+	if err != nil {
+		return err
+	}
+	*t = *resp.(*api.Token)
+	printf(nil, "  ")
+	printf(colorForToken(t), "%s\n", t.Cor)
+	return nil
+}
+
+// suggestions returns the profiler suggestions for the given word
+// ordered by their weight.
+func (r *review) suggestions(word string) ([]api.Suggestion, error) {
+	if suggs, ok := r.suggs[word]; ok {
+		return suggs, nil
+	}
+	var res api.Suggestions
+	uri := r.c.URL("profile/books/%d?q=%s", r.pos.BookID, url.QueryEscape(word))
+	if err := get(r.c, uri, &res); err != nil {
+		return nil, err
+	}
+	var suggs []api.Suggestion
+	for _, ss := range res.Suggestions {
+		suggs = append(suggs, ss...)
+	}
+	sort.SliceStable(suggs, func(i, j int) bool {
+		return suggs[i].Weight > suggs[j].Weight
+	})
+	r.suggs[word] = suggs
+	return suggs, nil
+}
+
+func reviewPath(bid int) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	name := getProfile().Name
+	if name == "" {
+		name = "default"
+	}
+	return filepath.Join(dir, "review", fmt.Sprintf("%s-%d.json", name, bid)), nil
+}
+
+// read reads the saved position.  Positions saved for other hosts
+// are ignored.
+func (pos *reviewPosition) read() error {
+	path, err := reviewPath(pos.BookID)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var saved reviewPosition
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("read %s: %v", path, err)
+	}
+	if saved.Host == pos.Host && saved.BookID == pos.BookID {
+		*pos = saved
+	}
+	return nil
+}
+
+func (pos *reviewPosition) write() error {
+	path, err := reviewPath(pos.BookID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(pos)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func (pos *reviewPosition) remove() error {
+	path, err := reviewPath(pos.BookID)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -43,10 +43,11 @@ var searchCommand = cobra.Command{
 }
 
 func runSearch(_ *cobra.Command, args []string) error {
-	var id int
-	if n := parseIDs(args[0], &id); n != 1 {
-		return fmt.Errorf("search: invalid book id: %q", args[0])
+	ids, err := parseID(args[0], bookLevels[:1])
+	if err != nil {
+		return fmt.Errorf("search: %v", err)
 	}
+	id := ids[0]
 	return search(id, args[1:]...)
 }
 
@@ -60,7 +61,7 @@ func hasAnyMatches(res *api.SearchResults) bool {
 }
 
 func search(id int, qs ...string) error {
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	c := authenticate()
 	skip := searchArgs.skip
 	for {
 		uri := c.URL("books/%d/search?i=%t&max=%d&skip=%d&type=%s",
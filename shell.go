@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/finkf/pcwgo/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// shellClient is the client that is shared by all commands of a
+// shell session.  It was created using shellClientArgs.
+var (
+	shellClient     *api.Client
+	shellClientArgs clientArgs
+)
+
+// clientArgs are the global settings of a client.
+type clientArgs struct {
+	url, auth, profile string
+	skipVerify         bool
+}
+
+func getClientArgs() clientArgs {
+	return clientArgs{
+		url:        mainArgs.pocowebURL,
+		auth:       mainArgs.authToken,
+		profile:    mainArgs.profile,
+		skipVerify: mainArgs.skipVerify,
+	}
+}
+
+func setClientArgs(args clientArgs) {
+	mainArgs.pocowebURL = args.url
+	mainArgs.authToken = args.auth
+	mainArgs.profile = args.profile
+	mainArgs.skipVerify = args.skipVerify
+}
+
+// shellAbort is raised by chk in shell mode.  It aborts the current
+// command of the shell with the given error.
+type shellAbort struct {
+	err error
+}
+
+// recoverAbort recovers from a shellAbort and stores its error in err.
+// It must be deferred in every goroutine that can call chk, since a
+// panic cannot be recovered in another goroutine.
+func recoverAbort(err *error) {
+	if r := recover(); r != nil {
+		abort, ok := r.(shellAbort)
+		if !ok {
+			panic(r)
+		}
+		*err = abort.err
+	}
+}
+
+var shellCommand = cobra.Command{
+	Use:   "shell",
+	Short: "Start an interactive shell",
+	Args:  cobra.NoArgs,
+	RunE:  runShell,
+	Long: `
+Start an interactive shell.  The shell reads pcwclient commands
+without the leading pcwclient and runs them using one shared client.
+The url, auth and profile settings of the shell are used for all
+commands that do not give their own settings.  Use the arrow keys to
+browse the history and tab to complete commands, flags and recently
+used IDs.
+
+The shell remembers a current book or page.  IDs given to print and
+correct are relative to it: in book 12, "print 3" prints page 12:3;
+in page 12:3, "correct 4:5 foo" corrects word 12:3:4:5.  Prefix an ID
+with / to use it as absolute ID.  The IDs of all other commands are
+always absolute.  The following builtin commands are available:
+
+  cd [ID]  set the current book or page (cd .. goes up, cd clears)
+  pwd      print the current book or page
+  exit     leave the shell`,
+}
+
+// idArg defines which positional arguments of a command are IDs.  If
+// relative is true, the IDs are resolved against the current book or
+// page.
+type idArg struct {
+	is       func(int) bool
+	relative bool
+}
+
+func allArgs(int) bool    { return true }
+func firstArg(i int) bool { return i == 0 }
+func evenArgs(i int) bool { return i%2 == 0 }
+
+// idArgs defines the ID arguments of all commands that take IDs.  The
+// IDs are remembered and completed.
+var idArgs = map[string]idArg{
+	"pcwclient print":             {allArgs, true},
+	"pcwclient correct":           {evenArgs, true},
+	"pcwclient apply rrdm":        {firstArg, false},
+	"pcwclient apply suggestions": {firstArg, false},
+	"pcwclient delete books":      {allArgs, false},
+	"pcwclient download book":     {firstArg, false},
+	"pcwclient export":            {firstArg, false},
+	"pcwclient export gt":         {firstArg, false},
+	"pcwclient job status":        {allArgs, false},
+	"pcwclient job wait":          {allArgs, false},
+	"pcwclient job watch":         {allArgs, false},
+	"pcwclient job cancel":        {allArgs, false},
+	"pcwclient list books":        {allArgs, false},
+	"pcwclient list patterns":     {firstArg, false},
+	"pcwclient list suggestions":  {firstArg, false},
+	"pcwclient list suspicious":   {allArgs, false},
+	"pcwclient list adaptive":     {allArgs, false},
+	"pcwclient list el":           {allArgs, false},
+	"pcwclient list rrdm":         {allArgs, false},
+	"pcwclient list chars":        {allArgs, false},
+	"pcwclient pipeline":          {firstArg, false},
+	"pcwclient pkg assign":        {firstArg, false},
+	"pcwclient pkg reassign":      {firstArg, false},
+	"pcwclient pkg split":         {firstArg, false},
+	"pcwclient replace":           {firstArg, false},
+	"pcwclient review":            {firstArg, false},
+	"pcwclient search":            {firstArg, false},
+	"pcwclient start profile":     {allArgs, false},
+	"pcwclient start el":          {allArgs, false},
+	"pcwclient start rrdm":        {allArgs, false},
+}
+
+var idRegex = func() *regexp.Regexp {
+	const item = `(\d+|first|last)(-(\d+|first|last))?`
+	const comp = `(\*|` + item + `(,` + item + `)*)`
+	return regexp.MustCompile(`^` + comp + `(:` + comp + `)*(/-?\d+)?$`)
+}()
+
+const maxRecentIDs = 50
+
+// shell holds the state of a shell session.
+type shell struct {
+	bookID, pageID int
+	recent         []string
+}
+
+func runShell(_ *cobra.Command, _ []string) error {
+	shellClientArgs = getClientArgs()
+	shellClient = authenticate()
+	defer func() { shellClient = nil }()
+	var sh shell
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			if sh.exec(s.Text()) {
+				return nil
+			}
+		}
+		return s.Err()
+	}
+	term := terminal.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "")
+	term.AutoCompleteCallback = sh.complete
+	for {
+		line, err := sh.readLine(fd, term)
+		if err == io.EOF {
+			fmt.Println()
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("shell: %v", err)
+		}
+		if sh.exec(line) {
+			return nil
+		}
+	}
+}
+
+// readLine reads the next line in raw mode.  The terminal is restored
+// afterwards, so commands can use the terminal as usual.
+func (sh *shell) readLine(fd int, term *terminal.Terminal) (string, error) {
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return "", err
+	}
+	defer terminal.Restore(fd, state)
+	if w, h, err := terminal.GetSize(fd); err == nil && w > 0 {
+		term.SetSize(w, h)
+	}
+	term.SetPrompt(sh.prompt())
+	return term.ReadLine()
+}
+
+func (sh *shell) prompt() string {
+	if ctx := sh.context(); ctx != "" {
+		return "pcwclient " + ctx + "> "
+	}
+	return "pcwclient> "
+}
+
+// context returns the ID of the current book or page.
+func (sh *shell) context() string {
+	switch {
+	case sh.pageID != 0:
+		return fmt.Sprintf("%d:%d", sh.bookID, sh.pageID)
+	case sh.bookID != 0:
+		return fmt.Sprintf("%d", sh.bookID)
+	default:
+		return ""
+	}
+}
+
+// exec executes the given line.  It returns true if the shell should
+// exit.
+func (sh *shell) exec(line string) bool {
+	args, err := splitWords(line)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return false
+	}
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "exit", "quit":
+		return true
+	case "pwd":
+		fmt.Println(sh.context())
+		return false
+	case "cd":
+		if err := sh.cd(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: cd: %v\n", err)
+		}
+		return false
+	}
+	if err := sh.run(args); err != nil {
+		var e exitError
+		if !errors.As(err, &e) || e.err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+	}
+	return false
+}
+
+func (sh *shell) cd(args []string) error {
+	switch {
+	case len(args) == 0:
+		sh.bookID, sh.pageID = 0, 0
+	case len(args) > 1:
+		return fmt.Errorf("too many arguments")
+	case args[0] == "..":
+		if sh.pageID != 0 {
+			sh.pageID = 0
+		} else {
+			sh.bookID = 0
+		}
+	default:
+		ids, err := parseID(strings.TrimPrefix(args[0], "/"), bookLevels[:2])
+		if err != nil {
+			return err
+		}
+		sh.bookID, sh.pageID = ids[0], 0
+		if len(ids) == 2 {
+			sh.pageID = ids[1]
+		}
+		sh.remember(args[0])
+	}
+	return nil
+}
+
+// run runs the given pcwclient command.  All flags are reset to their
+// defaults before the command runs.  The client settings are reset to
+// the settings of the shell.
+func (sh *shell) run(args []string) (err error) {
+	defer recoverAbort(&err)
+	cmd, rest, err := mainCommand.Find(args)
+	if err != nil {
+		return err
+	}
+	if cmd.CommandPath() == "pcwclient shell" {
+		return fmt.Errorf("already in shell mode")
+	}
+	if arg, ok := idArgs[cmd.CommandPath()]; ok {
+		sh.resolveIDs(cmd, rest, arg)
+		args = append(strings.Fields(cmd.CommandPath())[1:], rest...)
+	}
+	resetFlags(mainCommand)
+	setClientArgs(shellClientArgs)
+	mainCommand.SetArgs(args)
+	defer flushOutput()
+	return mainCommand.Execute()
+}
+
+// resolveIDs remembers the ID arguments of cmd and resolves relative
+// IDs in place.
+func (sh *shell) resolveIDs(cmd *cobra.Command, rest []string, arg idArg) {
+	defer resetFlags(mainCommand)
+	if err := cmd.ParseFlags(rest); err != nil {
+		return // the command reports the error
+	}
+	positional := cmd.Flags().Args()
+	for i, j := 0, 0; i < len(rest) && j < len(positional); i++ {
+		if rest[i] != positional[j] {
+			continue
+		}
+		if arg.is(j) && idRegex.MatchString(strings.TrimPrefix(rest[i], "/")) {
+			sh.remember(rest[i])
+			if arg.relative {
+				rest[i] = sh.resolve(rest[i])
+			}
+		}
+		j++
+	}
+}
+
+func (sh *shell) resolve(id string) string {
+	switch {
+	case strings.HasPrefix(id, "/"):
+		return id[1:]
+	case sh.pageID != 0:
+		return fmt.Sprintf("%d:%d:%s", sh.bookID, sh.pageID, id)
+	case sh.bookID != 0:
+		return fmt.Sprintf("%d:%s", sh.bookID, id)
+	default:
+		return id
+	}
+}
+
+func (sh *shell) remember(id string) {
+	recent := []string{id}
+	for _, r := range sh.recent {
+		if r != id && len(recent) < maxRecentIDs {
+			recent = append(recent, r)
+		}
+	}
+	sh.recent = recent
+}
+
+// resetFlags resets all changed flags of cmd and its sub commands to
+// their default values.
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		// The default value of slices is formatted as [a,b].
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			var def []string
+			if str := strings.Trim(f.DefValue, "[]"); str != "" {
+				def = strings.Split(str, ",")
+			}
+			sv.Replace(def)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, sub := range cmd.Commands() {
+		resetFlags(sub)
+	}
+}
+
+// complete completes the word before the cursor if tab is pressed.
+func (sh *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	head := line[:pos]
+	start := strings.LastIndex(head, " ") + 1
+	word := head[start:]
+	var matches []string
+	for _, cand := range sh.candidates(strings.Fields(head[:start]), word) {
+		if strings.HasPrefix(cand, word) {
+			matches = append(matches, cand)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	completion := commonPrefix(matches)
+	if len(matches) == 1 {
+		completion += " "
+	}
+	return head[:start] + completion + line[pos:], start + len(completion), true
+}
+
+// candidates returns the possible completions of word after the
+// given words.
+func (sh *shell) candidates(words []string, word string) []string {
+	cmd := mainCommand
+	for _, w := range words {
+		for _, sub := range cmd.Commands() {
+			if sub.Name() == w {
+				cmd = sub
+				break
+			}
+		}
+	}
+	var cands []string
+	if strings.HasPrefix(word, "-") {
+		add := func(f *pflag.Flag) {
+			cands = append(cands, "--"+f.Name)
+		}
+		cmd.Flags().VisitAll(add)
+		cmd.InheritedFlags().VisitAll(add)
+		return cands
+	}
+	if len(words) == 0 {
+		cands = append(cands, "cd", "pwd", "exit")
+	}
+	for _, sub := range cmd.Commands() {
+		cands = append(cands, sub.Name())
+	}
+	if _, ok := idArgs[cmd.CommandPath()]; ok {
+		cands = append(cands, sh.recent...)
+	}
+	sort.Strings(cands)
+	return cands
+}
+
+func commonPrefix(strs []string) string {
+	prefix := strs[0]
+	for _, str := range strs[1:] {
+		for !strings.HasPrefix(str, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// splitWords splits the line into words.  Words can be quoted using
+// single or double quotes.  A backslash escapes the next character
+// outside of single quotes.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord, escape := false, false
+	for _, r := range line {
+		switch {
+		case escape:
+			word.WriteRune(r)
+			escape = false
+		case r == '\\' && quote != '\'':
+			escape, inWord = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escape {
+		return nil, fmt.Errorf("unterminated quote or escape: %s", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestIDArgs(t *testing.T) {
+	// Commands for user IDs do not take book IDs.
+	users := map[string]bool{
+		"pcwclient list users":   true,
+		"pcwclient delete users": true,
+	}
+	var visit func(*cobra.Command)
+	visit = func(cmd *cobra.Command) {
+		for _, sub := range cmd.Commands() {
+			visit(sub)
+		}
+		path := cmd.CommandPath()
+		_, ok := idArgs[path]
+		takesIDs := strings.Contains(strings.ToUpper(cmd.Use), " ID") ||
+			strings.Contains(strings.ToUpper(cmd.Use), "[ID")
+		if takesIDs && !users[path] && !ok {
+			t.Errorf("%s: missing ID arguments", path)
+		}
+	}
+	visit(mainCommand)
+	for path := range idArgs {
+		cmd, _, err := mainCommand.Find(strings.Fields(path)[1:])
+		if err != nil || cmd.CommandPath() != path {
+			t.Errorf("%s: no such command", path)
+		}
+	}
+}
+
+func TestShellResolveIDs(t *testing.T) {
+	sh := shell{bookID: 12}
+	for _, tc := range []struct {
+		args, want []string
+	}{
+		{[]string{"print", "3", "/4"}, []string{"print", "12:3", "4"}},
+		{[]string{"correct", "3:4", "x", "/5:6:7", "y"},
+			[]string{"correct", "12:3:4", "x", "5:6:7", "y"}},
+		{[]string{"download", "book", "3"}, []string{"download", "book", "3"}},
+		{[]string{"start", "profile", "3", "first-last"},
+			[]string{"start", "profile", "3", "first-last"}},
+		{[]string{"search", "3", "4"}, []string{"search", "3", "4"}},
+	} {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd, rest, err := mainCommand.Find(tc.args)
+			if err != nil {
+				t.Fatalf("got error: %v", err)
+			}
+			sh.resolveIDs(cmd, rest, idArgs[cmd.CommandPath()])
+			got := append(strings.Fields(cmd.CommandPath())[1:], rest...)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %v; got %v", tc.want, got)
+			}
+		})
+	}
+	if want := []string{"3", "first-last", "/5:6:7", "3:4", "/4"}; !reflect.DeepEqual(sh.recent, want) {
+		t.Fatalf("expected recent IDs %v; got %v", want, sh.recent)
+	}
+}
+
+func TestRecoverAbort(t *testing.T) {
+	want := errors.New("abort")
+	err := func() (err error) {
+		defer recoverAbort(&err)
+		panic(shellAbort{want})
+	}()
+	if err != want {
+		t.Fatalf("expected %v; got %v", want, err)
+	}
+	errc := make(chan error)
+	go func() {
+		var err error
+		defer func() { errc <- err }()
+		defer recoverAbort(&err)
+		panic(shellAbort{want})
+	}()
+	if err := <-errc; err != want {
+		t.Fatalf("expected %v from goroutine; got %v", want, err)
+	}
+}
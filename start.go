@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/UNO-SOFT/ulog"
@@ -11,23 +14,39 @@ import (
 )
 
 var startArgs = struct {
-	nowait bool
-	sleep  int
+	nowait   bool
+	all      bool
+	sleep    int
+	parallel int
+	timeout  time.Duration
 }{}
 
 var startCommand = cobra.Command{
 	Use:   "start",
 	Short: "Start various jobs",
+	Long: `
+Start jobs for books.  All start commands accept multiple book IDs or
+--all to start the jobs for all books.  At most --parallel jobs run
+at the same time.  Running jobs are reattached.  If multiple books are
+given, a report with the result of each job is printed at the end.`,
 }
 
 func init() {
 	startCommand.PersistentFlags().BoolVarP(&startArgs.nowait, "nowait", "n", false,
 		"do not wait for the job to finish")
 	startCommand.PersistentFlags().IntVarP(&startArgs.sleep, "sleep", "s", 5,
-		"set the number of seconds to sleep between checks if the job has finished")
+		"set the maximal number of seconds to sleep between checks if the job has finished")
+	startCommand.PersistentFlags().DurationVar(&startArgs.timeout, "wait-timeout", 0,
+		"give up waiting after DURATION (0: wait forever)")
+	startCommand.PersistentFlags().BoolVarP(&startArgs.all, "all", "a", false,
+		"start the jobs for all books")
+	startCommand.PersistentFlags().IntVarP(&startArgs.parallel, "parallel", "p", 4,
+		"set the maximal number of jobs running at the same time")
 }
 
-func reattach(c *api.Client, jobID int) (bool, error) {
+// reattach returns true if the job of the given step is running.  It
+// returns an error if another job is running for the book.
+func reattach(c *api.Client, step jobStep, jobID int) (bool, error) {
 	if !startArgs.nowait {
 		var status api.JobStatus
 		if err := get(c, c.URL("jobs/%d", jobID), &status); err != nil {
@@ -35,6 +54,10 @@ func reattach(c *api.Client, jobID int) (bool, error) {
 				jobID, err)
 		}
 		if status.StatusID == db.StatusIDRunning {
+			if !step.matches(status.JobName) {
+				return false, fmt.Errorf("reattach to job %d: another job is running: %s",
+					jobID, status.JobName)
+			}
 			return true, nil
 		}
 	}
@@ -42,24 +65,26 @@ func reattach(c *api.Client, jobID int) (bool, error) {
 }
 
 func waitForJobToFinish(c *api.Client, jobID int) error {
-	for !startArgs.nowait {
-		var status api.JobStatus
-		if err := get(c, c.URL("jobs/%d", jobID), &status); err != nil {
-			return fmt.Errorf("get job status: %v", err)
-		}
-		if status.StatusID == db.StatusIDFailed {
-			return fmt.Errorf("job %d failed", status.JobID)
-		}
-		if status.StatusID == db.StatusIDDone {
-			return nil
-		}
-		time.Sleep(time.Duration(startArgs.sleep) * time.Second)
+	if startArgs.nowait {
+		return nil
+	}
+	status, err := waitForJob(c, jobID,
+		time.Duration(startArgs.sleep)*time.Second, startArgs.timeout)
+	if errors.Is(err, errWaitTimeout) {
+		return exitError{code: exitJobRunning, err: err}
+	}
+	if err != nil {
+		return fmt.Errorf("get job status: %v", err)
+	}
+	if status.StatusID == db.StatusIDFailed {
+		return exitError{code: exitJobFailed,
+			err: fmt.Errorf("job %d failed", status.JobID)}
 	}
 	return nil
 }
 
-func start(c *api.Client, id int, fn func() error) error {
-	re, err := reattach(c, id)
+func start(c *api.Client, step jobStep, id int, fn func() error) error {
+	re, err := reattach(c, step, id)
 	ulog.Write("start", "re", re, "err", err)
 	if err != nil {
 		return err
@@ -74,76 +99,229 @@ func start(c *api.Client, id int, fn func() error) error {
 	return waitForJobToFinish(c, id)
 }
 
-var startProfileCommand = cobra.Command{
-	Use:   "profile ID [ALEX-TOKENS...]",
-	Short: "Start to profile book ID",
-	Args:  cobra.MinimumNArgs(1),
-	RunE:  doProfile,
+// jobStep is a job that can be started for a book.
+type jobStep struct {
+	name   string   // name of the step
+	status string   // book status that marks the step as done
+	url    string   // url to start the job
+	jobs   []string // parts of the server's job names of the step
 }
 
-func doProfile(_ *cobra.Command, args []string) error {
-	var bid int
-	if n := parseIDs(args[0], &bid); n != 1 {
-		return fmt.Errorf("start profile: invalid book ID: %q", args[0])
+// jobSteps lists all job steps in the order they are run.
+var jobSteps = []jobStep{
+	{name: "profile", status: "profiled", url: "profile/books/%d",
+		jobs: []string{"profil"}},
+	{name: "el", status: "extended-lexicon", url: "postcorrect/le/books/%d",
+		jobs: []string{"lexicon"}},
+	{name: "rrdm", status: "post-corrected", url: "postcorrect/books/%d",
+		jobs: []string{"postcorrect", "post-correct", "rrdm"}},
+}
+
+func findJobStep(name string) (jobStep, bool) {
+	for _, step := range jobSteps {
+		if step.name == name {
+			return step, true
+		}
+	}
+	return jobStep{}, false
+}
+
+// matches returns true if the given job name belongs to the step.
+func (step jobStep) matches(jobName string) bool {
+	jobName = strings.ToLower(jobName)
+	for _, job := range step.jobs {
+		if strings.Contains(jobName, job) {
+			return true
+		}
 	}
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
+	return false
+}
+
+// run starts the job for the given book or reattaches to it and waits
+// for the job to finish.
+func (step jobStep) run(c *api.Client, bid int) error {
 	jobID := bid
-	err := start(c, jobID, func() error {
+	return start(c, step, jobID, func() error {
 		var job api.Job
-		return post(c, c.URL("/profile/books/%d", bid), nil, &job)
+		return post(c, c.URL(step.url, bid), nil, &job)
 	})
-	if err != nil {
-		return fmt.Errorf("start profile book %d: %v", bid, err)
-	}
-	return nil
+}
+
+var startProfileCommand = cobra.Command{
+	Use:   "profile [ID...]",
+	Short: "Start to profile books",
+	RunE:  doProfile,
+}
+
+func doProfile(_ *cobra.Command, args []string) error {
+	return startSteps("profile", args)
 }
 
 var startELCommand = cobra.Command{
-	Use:   "el ID",
-	Short: "Create extended lexicon for book ID",
-	Args:  cobra.ExactArgs(1),
+	Use:   "el [ID...]",
+	Short: "Create extended lexicons for books",
 	RunE:  doEL,
 }
 
 func doEL(_ *cobra.Command, args []string) error {
-	var bid int
-	if n := parseIDs(args[0], &bid); n != 1 {
-		return fmt.Errorf("start el: invalid book ID: %q",
-			args[0])
-	}
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
-	jobID := bid
-	err := start(c, jobID, func() error {
-		var job api.Job
-		return post(c, c.URL("postcorrect/le/books/%d", bid), nil, &job)
-	})
-	if err != nil {
-		return fmt.Errorf("start el for book %d: %v",
-			bid, err)
-	}
-	return nil
+	return startSteps("el", args)
 }
 
 var startRRDMCommand = cobra.Command{
-	Use:   "rrdm ID",
-	Short: "Start automatic post-correction on book ID",
-	Args:  cobra.ExactArgs(1),
+	Use:   "rrdm [ID...]",
+	Short: "Start automatic post-correction on books",
 	RunE:  doRRDM,
 }
 
 func doRRDM(_ *cobra.Command, args []string) error {
-	var bid int
-	if n := parseIDs(args[0], &bid); n != 1 {
-		return fmt.Errorf("start rrdm: invalid book ID: %q", args[0])
+	return startSteps("rrdm", args)
+}
+
+// startResult is the result of a job started for a book.
+type startResult struct {
+	BookID   int           `json:"bookId"`
+	Job      string        `json:"job"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// startReport is the final report of the jobs started for multiple
+// books.
+type startReport struct {
+	Results []startResult `json:"results"`
+}
+
+// Status of the start results.
+const (
+	startDone    = "done"
+	startStarted = "started"
+	startFailed  = "failed"
+	startTimeout = "timeout"
+	startError   = "error"
+)
+
+// startSteps starts the named step for all given books.  If exactly one
+// book is given, the job is started and waited for as usual.
+// Otherwise the jobs of the books are run concurrently and a report of
+// all jobs is printed at the end.
+func startSteps(name string, args []string) error {
+	switch {
+	case startArgs.all && len(args) > 0:
+		return fmt.Errorf("start %s: --all and book IDs are mutually exclusive", name)
+	case !startArgs.all && len(args) == 0:
+		return fmt.Errorf("start %s: missing book IDs (or --all)", name)
+	case startArgs.parallel < 1:
+		return fmt.Errorf("start %s: invalid --parallel: %d", name, startArgs.parallel)
 	}
-	c := api.Authenticate(getURL(), getAuth(), mainArgs.skipVerify)
-	jobID := bid
-	err := start(c, jobID, func() error {
-		var job api.Job
-		return post(c, c.URL("postcorrect/books/%d", bid), nil, &job)
-	})
+	step, _ := findJobStep(name)
+	c := authenticate()
+	bids, err := startBookIDs(c, args)
 	if err != nil {
-		return fmt.Errorf("start rrdm for book %d: %v", bid, err)
+		return fmt.Errorf("start %s: %v", name, err)
+	}
+	if len(bids) == 1 && !startArgs.all {
+		if err := step.run(c, bids[0]); err != nil {
+			return fmt.Errorf("start %s book %d: %w", name, bids[0], err)
+		}
+		return nil
+	}
+	jobProgressLog = true
+	defer func() { jobProgressLog = false }()
+	report := startReport{Results: make([]startResult, len(bids))}
+	sem := make(chan struct{}, startArgs.parallel)
+	var wg sync.WaitGroup
+	for i, bid := range bids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(res *startResult, bid int) {
+			defer func() { <-sem; wg.Done() }()
+			*res = runStartStep(c, step, bid)
+		}(&report.Results[i], bid)
+	}
+	wg.Wait()
+	format(&report)
+	return report.err(name)
+}
+
+// startBookIDs returns the IDs of all books given by the ID patterns or
+// of all books if --all is given.  Packages are not included in --all.
+func startBookIDs(c *api.Client, args []string) ([]int, error) {
+	var bids []int
+	if startArgs.all {
+		books, err := getAllBooks(c)
+		if err != nil {
+			return nil, err
+		}
+		for _, book := range books.Books {
+			if book.IsBook {
+				bids = append(bids, book.ProjectID)
+			}
+		}
+		return bids, nil
+	}
+	for _, id := range args {
+		err := eachID(c, id, bookLevels[:1], func(ids []int) error {
+			bids = append(bids, ids[0])
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bids, nil
+}
+
+func runStartStep(c *api.Client, step jobStep, bid int) startResult {
+	res := startResult{BookID: bid, Job: step.name, Status: startDone}
+	if startArgs.nowait {
+		res.Status = startStarted
+	}
+	t := time.Now()
+	err := func() (err error) {
+		defer recoverAbort(&err) // runStartStep runs in its own goroutine
+		return step.run(c, bid)
+	}()
+	res.Duration = time.Since(t).Round(time.Second)
+	switch {
+	case errors.Is(err, errWaitTimeout):
+		res.Status = startTimeout
+	case err != nil:
+		var e exitError
+		if errors.As(err, &e) && e.code == exitJobFailed {
+			res.Status = startFailed
+		} else {
+			res.Status, res.Error = startError, err.Error()
+		}
+	}
+	return res
+}
+
+// err returns an error if any job of the report did not succeed.  The
+// exit code follows the job commands.
+func (r *startReport) err(name string) error {
+	var failed, errs, timeouts int
+	for _, res := range r.Results {
+		switch res.Status {
+		case startFailed:
+			failed++
+		case startError:
+			errs++
+		case startTimeout:
+			timeouts++
+		}
+	}
+	n := len(r.Results)
+	switch {
+	case errs > 0:
+		return fmt.Errorf("start %s: %d of %d books failed", name, errs+failed, n)
+	case failed > 0:
+		return exitError{code: exitJobFailed,
+			err: fmt.Errorf("start %s: %d of %d jobs failed", name, failed, n)}
+	case timeouts > 0:
+		return exitError{code: exitJobRunning,
+			err: fmt.Errorf("start %s: %d of %d jobs still running", name, timeouts, n)}
+	default:
+		return nil
 	}
-	return nil
 }
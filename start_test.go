@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/finkf/pcwgo/api"
+	"github.com/finkf/pcwgo/db"
+)
+
+// newStartTestServer returns a server that starts profiler jobs for
+// books.  The jobs of book 1 are done, the jobs of book 2 fail and
+// the jobs of book 3 run forever.  Another job runs for book 4 and
+// starting jobs for book 5 is forbidden.
+func newStartTestServer(t *testing.T) *httptest.Server {
+	states := map[string]api.JobStatus{
+		"/rest/jobs/1": {JobID: 1, BookID: 1, StatusID: db.StatusIDDone, JobName: "profiler"},
+		"/rest/jobs/2": {JobID: 2, BookID: 2, StatusID: db.StatusIDFailed, JobName: "profiler"},
+		"/rest/jobs/3": {JobID: 3, BookID: 3, StatusID: db.StatusIDRunning, JobName: "profiler"},
+		"/rest/jobs/4": {JobID: 4, BookID: 4, StatusID: db.StatusIDRunning, JobName: "lexicon"},
+		"/rest/jobs/5": {JobID: 5, BookID: 5, StatusID: db.StatusIDDone, JobName: "profiler"},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply := func(code int, data interface{}) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			if err := json.NewEncoder(w).Encode(data); err != nil {
+				t.Errorf("encode %s: %v", r.URL, err)
+			}
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/rest/profile/books/5":
+			reply(http.StatusForbidden, api.ErrorResponse{StatusCode: http.StatusForbidden,
+				Status: "403 Forbidden"})
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/rest/profile/books/"):
+			reply(http.StatusOK, api.Job{})
+		case r.Method == http.MethodGet:
+			status, ok := states[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			reply(http.StatusOK, status)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestRunStartStep(t *testing.T) {
+	defer func(nowait bool, sleep int, timeout time.Duration) {
+		startArgs.nowait, startArgs.sleep, startArgs.timeout = nowait, sleep, timeout
+	}(startArgs.nowait, startArgs.sleep, startArgs.timeout)
+	startArgs.sleep, startArgs.timeout = 0, 50*time.Millisecond
+	srv := newStartTestServer(t)
+	defer srv.Close()
+	c := api.Authenticate(srv.URL, "", false)
+	step, _ := findJobStep("profile")
+	for _, tc := range []struct {
+		bid    int
+		nowait bool
+		want   string
+		err    string
+	}{
+		{1, false, startDone, ""},
+		{2, false, startFailed, ""},
+		{3, false, startTimeout, ""},
+		{4, false, startError, "another job is running: lexicon"},
+		{5, false, startError, "403"},
+		{2, true, startStarted, ""},
+		{3, true, startStarted, ""},
+	} {
+		t.Run(fmt.Sprintf("%d nowait=%t", tc.bid, tc.nowait), func(t *testing.T) {
+			startArgs.nowait = tc.nowait
+			got := runStartStep(c, step, tc.bid)
+			if got.BookID != tc.bid || got.Job != "profile" || got.Status != tc.want {
+				t.Fatalf("expected book %d, job profile and status %s; got %+v",
+					tc.bid, tc.want, got)
+			}
+			if !strings.Contains(got.Error, tc.err) || (tc.err == "") != (got.Error == "") {
+				t.Fatalf("expected error %q; got %q", tc.err, got.Error)
+			}
+		})
+	}
+}
+
+func TestStartReportErr(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		states []string
+		err    string
+		code   int
+	}{
+		{"none", nil, "", 0},
+		{"done", []string{startDone, startDone}, "", 0},
+		{"started", []string{startStarted, startDone}, "", 0},
+		{"failed", []string{startDone, startFailed}, "1 of 2 jobs failed", exitJobFailed},
+		{"timeout", []string{startTimeout, startDone, startTimeout},
+			"2 of 3 jobs still running", exitJobRunning},
+		{"failed timeout", []string{startTimeout, startFailed}, "1 of 2 jobs failed", exitJobFailed},
+		{"error", []string{startError, startDone}, "1 of 2 books failed", 0},
+		{"error failed timeout", []string{startError, startFailed, startTimeout},
+			"2 of 3 books failed", 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var report startReport
+			for i, status := range tc.states {
+				report.Results = append(report.Results,
+					startResult{BookID: i + 1, Job: "profile", Status: status})
+			}
+			err := report.err("profile")
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("got error: %v", err)
+				}
+				return
+			}
+			want := "start profile: " + tc.err
+			if err == nil || err.Error() != want {
+				t.Fatalf("expected error %q; got %v", want, err)
+			}
+			var code int
+			if e := (exitError{}); errors.As(err, &e) {
+				code = e.code
+			}
+			if code != tc.code {
+				t.Fatalf("expected exit code %d; got %d", tc.code, code)
+			}
+		})
+	}
+}
@@ -1,23 +1,34 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/UNO-SOFT/ulog"
 	"github.com/finkf/pcwgo/api"
 	"github.com/spf13/cobra"
 )
 
+// chk exits with the given error.  In shell mode, the error is
+// returned from the current command instead (see shell.run).
 func chk(err error) {
 	if err == nil {
 		return
 	}
+	if shellClient != nil {
+		panic(shellAbort{err})
+	}
 	log.Fatalf("error: %v", err)
 }
 
@@ -33,19 +44,6 @@ func exactArgs(allowed ...int) func(_ *cobra.Command, args []string) error {
 	}
 }
 
-func parseIDs(id string, ids ...*int) int {
-	split := strings.Split(id, ":")
-	var i int
-	for i = 0; i < len(ids) && i < len(split); i++ {
-		id, err := strconv.Atoi(split[i])
-		if err != nil {
-			return 0
-		}
-		*ids[i] = id
-	}
-	return i
-}
-
 func unescape(args ...string) []string {
 	res := make([]string, len(args))
 	for i := range args {
@@ -59,52 +57,303 @@ func unescape(args ...string) []string {
 	return res
 }
 
+// Sources of the pocoweb url.
+const (
+	urlFromFlag = iota
+	urlFromEnv
+	urlFromProfile
+)
+
+// getURL returns the pocoweb url.  The url is looked up in the --url
+// flag, the POCOWEB_URL environment variable and the active profile
+// in this order.  An explicit --profile takes precedence over the
+// environment.
 func getURL() string {
+	url, _ := getURLSource()
+	return url
+}
+
+// getURLSource returns the pocoweb url and its source.
+func getURLSource() (string, int) {
 	if mainArgs.pocowebURL != "" {
-		return mainArgs.pocowebURL
+		return mainArgs.pocowebURL, urlFromFlag
+	}
+	if url := os.Getenv("POCOWEB_URL"); url != "" && mainArgs.profile == "" {
+		return url, urlFromEnv
 	}
-	return os.Getenv("POCOWEB_URL")
+	return getProfile().URL, urlFromProfile
 }
 
+// getAuth returns the auth token.  An explicit --auth flag is always
+// used.  Otherwise a stored, unexpired session for the pocoweb url
+// takes precedence over the token of the url's source: POCOWEB_AUTH is
+// only used with POCOWEB_URL and the profile's token only with the
+// profile's url.  Tokens are never sent to another host.
 func getAuth() string {
 	if mainArgs.authToken != "" {
 		return mainArgs.authToken
 	}
-	return os.Getenv("POCOWEB_AUTH")
+	url, src := getURLSource()
+	if s, err := readSession(url); err == nil && !s.Expired() {
+		return s.Auth
+	}
+	switch src {
+	case urlFromEnv:
+		return os.Getenv("POCOWEB_AUTH")
+	case urlFromProfile:
+		return getProfile().Token
+	default:
+		return ""
+	}
+}
+
+func getSkipVerify() bool {
+	return mainArgs.skipVerify || getProfile().SkipVerify
+}
+
+// authenticate returns a new authenticated client.  In shell mode,
+// the client of the shell is returned unless the command changed the
+// client settings of the shell.
+func authenticate() *api.Client {
+	if shellClient != nil && getClientArgs() == shellClientArgs {
+		return shellClient
+	}
+	return api.Authenticate(getURL(), getAuth(), getSkipVerify())
 }
 
 func get(c *api.Client, url string, out interface{}) error {
-	ulog.Write("get", "method", "GET", "url", url, "auth", c.Session.Auth)
-	return c.Get(url, out)
+	ulog.Write("get", "method", "GET", "url", url, "auth", sessionAuth(c))
+	return do(c, http.MethodGet, url, nil, out)
 }
 
 func post(c *api.Client, url string, payload, out interface{}) error {
-	ulog.Write("post", "method", "POST", "url", url, "auth", c.Session.Auth)
-	return c.Post(url, payload, out)
+	ulog.Write("post", "method", "POST", "url", url, "auth", sessionAuth(c))
+	return do(c, http.MethodPost, url, payload, out)
+}
+
+func put(c *api.Client, url string, payload, out interface{}) error {
+	ulog.Write("put", "method", "PUT", "url", url, "auth", sessionAuth(c))
+	return do(c, http.MethodPut, url, payload, out)
 }
 
 func delete(c *api.Client, url string, out interface{}) error {
-	ulog.Write("post", "method", "DELETE", "url", url, "auth", c.Session.Auth)
-	return c.Delete(url, nil)
+	ulog.Write("delete", "method", "DELETE", "url", url, "auth", sessionAuth(c))
+	return do(c, http.MethodDelete, url, nil, out)
 }
 
-func downloadZIP(c *api.Client, url string, out io.Writer) error {
-	ulog.Write("download zip", "method", "GET", "url", url, "auth", c.Session.Auth)
-	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+// do sends an authenticated request with the given (json encoded)
+// payload and unmarshals the response into out.  Idempotent requests
+// are retried on temporary errors.  If the request fails with an
+// authentication error, do tries to login again and retries the
+// request once.
+func do(c *api.Client, method, url string, payload, out interface{}) error {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("%s %s: %v", method, url, err)
+		}
+	}
+	send := func() error {
+		return doOnce(c, method, url, body, out)
+	}
+	auth := sessionAuth(c)
+	err := retry(method, send)
+	if isAuthError(err) && relogin(c, auth) == nil {
+		err = retry(method, send)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, url, err)
+	}
+	return nil
+}
+
+func doOnce(c *api.Client, method, url string, body []byte, out interface{}) error {
+	req, cancel, err := newRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	res, err := doRequest(c, req)
+	if err != nil {
+		return networkError{err}
+	}
+	return api.UnmarshalResponse(res, out)
+}
+
+// doRequest sends the request with the client's current session.  The
+// global timeout applies until the response headers are received.
+// Reading the response body is not limited, so large downloads are
+// not canceled.
+func doRequest(c *api.Client, req *http.Request) (*http.Response, error) {
+	return doRequestTimeout(c, req, mainArgs.timeout)
+}
+
+// doRequestTimeout sends the request with the client's current
+// session.  If timeout is positive and no response is received within
+// timeout, the request is canceled.
+func doRequestTimeout(c *api.Client, req *http.Request, timeout time.Duration) (*http.Response, error) {
+	sessionMutex.RLock()
+	cc := *c
+	sessionMutex.RUnlock()
+	if !sameHost(c, req.URL) {
+		cc.Session = api.Session{} // never send the auth token to other hosts
+	}
+	if timeout <= 0 {
+		return cc.Do(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(timeout, cancel)
+	res, err := cc.Do(req.WithContext(ctx))
+	if !timer.Stop() {
+		if err == nil {
+			res.Body.Close()
+		}
+		return nil, fmt.Errorf("no response after %s", timeout)
+	}
+	return res, err
+}
+
+// sameHost returns true if u belongs to the pocoweb instance of the
+// client.
+func sameHost(c *api.Client, u *url.URL) bool {
+	host, err := url.Parse(c.Host)
 	if err != nil {
+		return false
+	}
+	return host.Scheme == u.Scheme && host.Host == u.Host
+}
+
+// newRequest creates a new request.  The returned cancel function must
+// be called after the response body was read.
+func newRequest(method, url string, body io.Reader) (*http.Request, context.CancelFunc, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return req.WithContext(ctx), cancel, nil
+}
+
+// networkError marks errors of requests that did not get any
+// response.
+type networkError struct {
+	err error
+}
+
+func (err networkError) Error() string {
+	return err.err.Error()
+}
+
+// retry calls send until it succeeds, fails with a permanent error
+// or the number of retries is exhausted.  Requests that are not
+// idempotent are never retried.
+func retry(method string, send func() error) error {
+	err := send()
+	switch method {
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+	default:
 		return err
 	}
-	res, err := c.Do(req)
+	for i := 0; i < mainArgs.retries && isTemporary(err); i++ {
+		wait := backoff(i)
+		ulog.Write("retry", "method", method, "error", err, "wait", wait.String())
+		time.Sleep(wait)
+		err = send()
+	}
+	return err
+}
+
+// isTemporary returns true for network errors and server errors.
+func isTemporary(err error) bool {
+	switch e := err.(type) {
+	case networkError:
+		return true
+	case api.ErrorResponse:
+		return e.StatusCode >= 500
+	default:
+		return false
+	}
+}
+
+// backoff returns the exponential backoff with jitter for the given
+// retry.
+func backoff(i int) time.Duration {
+	d := mainArgs.retryBackoff << uint(i)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+func isAuthError(err error) bool {
+	if e, ok := err.(api.ErrorResponse); ok {
+		return isAuthStatus(e.StatusCode)
+	}
+	return false
+}
+
+func isAuthStatus(code int) bool {
+	return code == http.StatusUnauthorized || code == http.StatusForbidden
+}
+
+func downloadZIP(c *api.Client, url string, out io.Writer) error {
+	ulog.Write("download zip", "method", "GET", "url", url, "auth", sessionAuth(c))
+	return getRaw(c, url, "application/zip", out)
+}
+
+// getRaw copies the raw response body of a get request to out.  The
+// response's content type must start with the given content type.
+func getRaw(c *api.Client, url, contentType string, out io.Writer) error {
+	auth := sessionAuth(c)
+	res, cancel, err := getResponse(c, url)
+	if err == nil && isAuthStatus(res.StatusCode) && sameHost(c, res.Request.URL) &&
+		relogin(c, auth) == nil {
+		res.Body.Close()
+		cancel()
+		res, cancel, err = getResponse(c, url)
+	}
 	if err != nil {
 		return err
 	}
+	defer cancel()
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
 		return fmt.Errorf("bad status code: %s", res.Status)
 	}
-	if ct := res.Header.Get("Content-Type"); ct != "application/zip" {
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, contentType) {
 		return fmt.Errorf("bad content type: %s", ct)
 	}
 	_, err = io.Copy(out, res.Body)
 	return err
 }
+
+// getResponse sends a get request and retries it on temporary errors.
+// The response body is not read.
+func getResponse(c *api.Client, url string) (*http.Response, context.CancelFunc, error) {
+	var res *http.Response
+	var cancel context.CancelFunc
+	err := retry(http.MethodGet, func() error {
+		req, cncl, err := newRequest(http.MethodGet, url, http.NoBody)
+		if err != nil {
+			return err
+		}
+		r, err := doRequest(c, req)
+		if err != nil {
+			cncl()
+			return networkError{err}
+		}
+		if r.StatusCode >= 500 {
+			r.Body.Close()
+			cncl()
+			return api.ErrorResponse{Status: r.Status, StatusCode: r.StatusCode}
+		}
+		res, cancel = r, cncl
+		return nil
+	})
+	return res, cancel, err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/finkf/pcwgo/api"
+)
+
+func TestIsTemporary(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("error"), false},
+		{networkError{errors.New("connection refused")}, true},
+		{api.ErrorResponse{StatusCode: http.StatusInternalServerError}, true},
+		{api.ErrorResponse{StatusCode: http.StatusBadGateway}, true},
+		{api.ErrorResponse{StatusCode: http.StatusServiceUnavailable}, true},
+		{api.ErrorResponse{StatusCode: http.StatusNotFound}, false},
+		{api.ErrorResponse{StatusCode: http.StatusUnauthorized}, false},
+		{api.ErrorResponse{StatusCode: http.StatusConflict}, false},
+	} {
+		t.Run(fmt.Sprint(tc.err), func(t *testing.T) {
+			if got := isTemporary(tc.err); got != tc.want {
+				t.Fatalf("expected %t; got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	defer func(retries int, backoff time.Duration) {
+		mainArgs.retries, mainArgs.retryBackoff = retries, backoff
+	}(mainArgs.retries, mainArgs.retryBackoff)
+	mainArgs.retries, mainArgs.retryBackoff = 3, 0
+	temporary := networkError{errors.New("temporary")}
+	permanent := api.ErrorResponse{StatusCode: http.StatusNotFound}
+	for _, tc := range []struct {
+		name   string
+		method string
+		errs   []error // errors of the subsequent calls; nil afterwards
+		calls  int
+		err    bool
+	}{
+		{"get ok", http.MethodGet, nil, 1, false},
+		{"get temporary", http.MethodGet, []error{temporary, temporary}, 3, false},
+		{"get exhausted", http.MethodGet, []error{temporary, temporary, temporary, temporary}, 4, true},
+		{"get permanent", http.MethodGet, []error{temporary, permanent}, 2, true},
+		{"put temporary", http.MethodPut, []error{temporary}, 2, false},
+		{"delete temporary", http.MethodDelete, []error{temporary}, 2, false},
+		{"post temporary", http.MethodPost, []error{temporary}, 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			err := retry(tc.method, func() error {
+				calls++
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+				return nil
+			})
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %t; got %v", tc.err, err)
+			}
+			if calls != tc.calls {
+				t.Fatalf("expected %d calls; got %d", tc.calls, calls)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	defer func(backoff time.Duration) {
+		mainArgs.retryBackoff = backoff
+	}(mainArgs.retryBackoff)
+	mainArgs.retryBackoff = time.Second
+	for i, want := range []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+	} {
+		for j := 0; j < 100; j++ {
+			if got := backoff(i); got < want/2 || got >= want/2*3 {
+				t.Fatalf("backoff(%d): expected [%s, %s); got %s", i, want/2, want/2*3, got)
+			}
+		}
+	}
+	mainArgs.retryBackoff = 0
+	if got := backoff(2); got != 0 {
+		t.Fatalf("expected no backoff; got %s", got)
+	}
+}
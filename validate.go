@@ -0,0 +1,306 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var validateCommand = cobra.Command{
+	Use:   "validate",
+	Short: "Validate books before uploading them",
+}
+
+var validateBookCommand = cobra.Command{
+	Use:   "book ZIP|DIR",
+	Short: "Validate the book archive or directory ZIP|DIR",
+	Args:  cobra.ExactArgs(1),
+	RunE:  doValidateBook,
+	Long: `
+Validate a book archive or directory.  The OCR format of the page
+files (PAGE XML, ABBYY, hOCR or ALTO) is detected and it is checked
+that every page file has a matching image file.  Unparseable XML
+files and books with mixed OCR formats are reported as warnings.`,
+}
+
+// validateBeforeUpload validates the book at p and writes all
+// problems to stderr.  An error is returned if the validation
+// detected any errors.
+func validateBeforeUpload(p string) error {
+	report, err := validateBook(p)
+	if err != nil {
+		return err
+	}
+	for _, problem := range report.Problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if n := report.errors(); n > 0 {
+		return fmt.Errorf("invalid book: %d error(s) (use --no-validate to skip the validation)", n)
+	}
+	return nil
+}
+
+func doValidateBook(_ *cobra.Command, args []string) error {
+	report, err := validateBook(args[0])
+	if err != nil {
+		return fmt.Errorf("validate book %s: %v", args[0], err)
+	}
+	format(report)
+	if report.errors() > 0 {
+		return fmt.Errorf("validate book %s: %d error(s)", args[0], report.errors())
+	}
+	return nil
+}
+
+// OCR formats of page files.
+const (
+	ocrFormatPAGE  = "page"
+	ocrFormatABBYY = "abbyy"
+	ocrFormatHOCR  = "hocr"
+	ocrFormatALTO  = "alto"
+)
+
+// problem defines a validation problem of a file in a book.
+type problem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+	Error   bool   `json:"error"`
+}
+
+// validationReport holds the results of the validation of a book.
+type validationReport struct {
+	Path     string         `json:"path"`
+	Formats  map[string]int `json:"formats"`
+	Images   int            `json:"images"`
+	Problems []problem      `json:"problems"`
+}
+
+func (p problem) String() string {
+	typ := "warning"
+	if p.Error {
+		typ = "error"
+	}
+	return fmt.Sprintf("%s %s %s", typ, p.Path, p.Message)
+}
+
+func (r *validationReport) warn(path, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, problem{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *validationReport) error(path, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, problem{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+		Error:   true,
+	})
+}
+
+func (r *validationReport) errors() int {
+	var n int
+	for _, p := range r.Problems {
+		if p.Error {
+			n++
+		}
+	}
+	return n
+}
+
+// bookFile is a file in a book archive or directory.
+type bookFile struct {
+	path string
+	open func() (io.ReadCloser, error)
+}
+
+func validateBook(p string) (*validationReport, error) {
+	files, closer, err := listBookFiles(p)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+	report := &validationReport{Path: p, Formats: make(map[string]int)}
+	images := make(map[string]bool)
+	pages := make(map[string]string)
+	var paths []string
+	for _, file := range files {
+		switch {
+		case isImageFile(file.path):
+			images[stem(file.path)] = true
+			report.Images++
+		case isOCRFile(file.path):
+			format, err := detectOCRFormat(file)
+			if err != nil {
+				report.warn(file.path, "%v", err)
+				continue
+			}
+			if format == "" {
+				continue
+			}
+			report.Formats[format]++
+			pages[file.path] = format
+			paths = append(paths, file.path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if !images[stem(path)] {
+			report.error(path, "missing image for %s page file", pages[path])
+		}
+	}
+	if len(pages) == 0 {
+		report.error(p, "no page files found")
+	}
+	if len(report.Formats) > 1 {
+		var formats []string
+		for format, n := range report.Formats {
+			formats = append(formats, fmt.Sprintf("%s(%d)", format, n))
+		}
+		sort.Strings(formats)
+		report.warn(p, "mixed ocr formats: %s", strings.Join(formats, ","))
+	}
+	return report, nil
+}
+
+func listBookFiles(p string) ([]bookFile, func(), error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !fi.IsDir() {
+		return listZIPFiles(p)
+	}
+	var files []bookFile
+	err = filepath.Walk(p, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		files = append(files, bookFile{path: p, open: func() (io.ReadCloser, error) {
+			return os.Open(p)
+		}})
+		return nil
+	})
+	return files, func() {}, err
+}
+
+func listZIPFiles(p string) ([]bookFile, func(), error) {
+	r, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []bookFile
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files = append(files, bookFile{path: f.Name, open: f.Open})
+	}
+	return files, func() { r.Close() }, nil
+}
+
+// stem returns the base name of the path up to its first dot.
+func stem(p string) string {
+	base := path.Base(filepath.ToSlash(p))
+	if pos := strings.Index(base, "."); pos > 0 {
+		return base[:pos]
+	}
+	return base
+}
+
+func isImageFile(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".png", ".jpg", ".jpeg", ".tif", ".tiff":
+		return true
+	}
+	return false
+}
+
+func isOCRFile(p string) bool {
+	return isXMLFile(p) || isHOCRFile(p)
+}
+
+func isXMLFile(p string) bool {
+	return strings.ToLower(path.Ext(p)) == ".xml"
+}
+
+func isHOCRFile(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".hocr", ".html", ".htm", ".xhtml":
+		return true
+	}
+	return false
+}
+
+// detectOCRFormat returns the OCR format of the given file.  An
+// empty string is returned for files that are not page files.
+func detectOCRFormat(file bookFile) (string, error) {
+	in, err := file.open()
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return "", err
+	}
+	if isHOCRFile(file.path) {
+		// hOCR files are not necessarily valid XML.
+		if bytes.Contains(data, []byte("ocr_page")) {
+			return ocrFormatHOCR, nil
+		}
+		return "", nil
+	}
+	root, err := xmlRoot(data)
+	if err != nil {
+		return "", fmt.Errorf("unparseable xml: %v", err)
+	}
+	switch {
+	case root.Local == "PcGts":
+		return ocrFormatPAGE, nil
+	case root.Local == "alto":
+		return ocrFormatALTO, nil
+	case root.Local == "document" && strings.Contains(root.Space, "abbyy"):
+		return ocrFormatABBYY, nil
+	case root.Local == "html" && bytes.Contains(data, []byte("ocr_page")):
+		return ocrFormatHOCR, nil
+	}
+	return "", nil
+}
+
+// xmlRoot returns the name of the root element of the given XML
+// document.  The whole document is parsed to detect syntax errors.
+func xmlRoot(data []byte) (xml.Name, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = true
+	d.CharsetReader = func(_ string, in io.Reader) (io.Reader, error) {
+		return in, nil
+	}
+	var root xml.Name
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return root, err
+		}
+		if se, ok := tok.(xml.StartElement); ok && root.Local == "" {
+			root = se.Name
+		}
+	}
+	if root.Local == "" {
+		return root, fmt.Errorf("missing root element")
+	}
+	return root, nil
+}